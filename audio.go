@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the default step for volume adjustments, in percent
+	defaultVolumeStep = 5
+	// how long to wait before following audio events again
+	audioRestartDelay = 5 * time.Second
+)
+
+var (
+	// gets incremented whenever a sink, source or the server changes
+	audioChanges uint64
+	// makes sure audio events only get followed once
+	watchAudioOnce sync.Once
+
+	volumePercent = regexp.MustCompile(`(\d+)%`)
+)
+
+// pactl runs pactl and returns its output.
+var pactl = func(args ...string) (string, error) {
+	out, err := exec.Command("pactl", args...).Output()
+	return string(out), err
+}
+
+// audioDevice is a sink or source of PulseAudio or PipeWire.
+type audioDevice struct {
+	kind string // sink or source
+	name string // empty for the default device
+}
+
+// newAudioDevice returns the sink or source with the given name.
+func newAudioDevice(kind, name string) (audioDevice, error) {
+	switch kind {
+	case "":
+		kind = "sink"
+	case "sink", "source":
+	default:
+		return audioDevice{}, fmt.Errorf("unknown audio device %s, expected sink or source", kind)
+	}
+
+	return audioDevice{
+		kind: kind,
+		name: name,
+	}, nil
+}
+
+// returns the name pactl knows the device by.
+func (d audioDevice) target() string {
+	if d.name != "" {
+		return d.name
+	}
+
+	return "@DEFAULT_" + strings.ToUpper(d.kind) + "@"
+}
+
+// state returns the device's volume in percent and whether it's muted.
+func (d audioDevice) state() (float64, bool, error) {
+	out, err := pactl("get-"+d.kind+"-volume", d.target())
+	if err != nil {
+		return 0, false, err
+	}
+	volume, err := parseVolume(out)
+	if err != nil {
+		return 0, false, err
+	}
+
+	out, err = pactl("get-"+d.kind+"-mute", d.target())
+	if err != nil {
+		return 0, false, err
+	}
+
+	return volume, strings.Contains(out, "yes"), nil
+}
+
+// setVolume sets the device's volume in percent.
+func (d audioDevice) setVolume(volume float64) error {
+	_, err := pactl("set-"+d.kind+"-volume", d.target(), strconv.Itoa(int(math.Round(volume)))+"%")
+	return err
+}
+
+// setMute mutes or unmutes the device. mute is either "on", "off" or "toggle".
+func (d audioDevice) setMute(mute string) error {
+	arg, err := muteArg(mute)
+	if err != nil {
+		return err
+	}
+
+	_, err = pactl("set-"+d.kind+"-mute", d.target(), arg)
+	return err
+}
+
+// parses the output of pactl get-sink-volume, returning the average volume of
+// all channels.
+func parseVolume(s string) (float64, error) {
+	matches := volumePercent.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("can't parse volume: %s", strings.TrimSpace(s))
+	}
+
+	var sum float64
+	for _, m := range matches {
+		v, _ := strconv.ParseFloat(m[1], 64)
+		sum += v
+	}
+
+	return sum / float64(len(matches)), nil
+}
+
+// returns the pactl argument for a mute setting.
+func muteArg(mute string) (string, error) {
+	switch mute {
+	case "toggle":
+		return "toggle", nil
+	case "on":
+		return "1", nil
+	case "off":
+		return "0", nil
+	}
+
+	return "", fmt.Errorf("invalid mute setting %s, expected on, off or toggle", mute)
+}
+
+// parses a volume adjustment like "+5", "-5" or "=50" relative to the
+// current volume. The result stays between 0 and 100.
+func parseVolumeAdjustment(value string, current float64) (float64, error) {
+	if len(value) == 0 {
+		return 0, errors.New("no volume adjustment specified")
+	}
+
+	v := float64(defaultVolumeStep)
+	if len(value) > 1 {
+		var err error
+		v, err = strconv.ParseFloat(value[1:], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid volume adjustment %s", value)
+		}
+	}
+
+	switch value[0] {
+	case '=':
+	case '+':
+		v = current + v
+	case '-':
+		v = current - v
+	default:
+		return 0, fmt.Errorf("invalid volume adjustment %s", value)
+	}
+
+	return math.Max(0, math.Min(100, v)), nil
+}
+
+// adjustVolume executes a volume action.
+func adjustVolume(c VolumeConfig) error {
+	d, err := newAudioDevice(c.Device, c.Name)
+	if err != nil {
+		return err
+	}
+	// repaint volume widgets right away
+	defer atomic.AddUint64(&audioChanges, 1)
+
+	if c.Adjust != "" {
+		current, _, err := d.state()
+		if err != nil {
+			return err
+		}
+		v, err := parseVolumeAdjustment(c.Adjust, current)
+		if err != nil {
+			return err
+		}
+		if err := d.setVolume(v); err != nil {
+			return err
+		}
+	}
+	if c.Mute != "" {
+		return d.setMute(c.Mute)
+	}
+
+	return nil
+}
+
+// watchAudio follows the events reported by pactl in the background, so volume
+// widgets get repainted as soon as anything changes.
+func watchAudio() {
+	watchAudioOnce.Do(func() {
+		if _, err := exec.LookPath("pactl"); err != nil {
+			verbosef("Can't follow audio events: %s", err)
+			return
+		}
+
+		go func() {
+			for {
+				err := followAudioEvents()
+				verbosef("Stopped following audio events: %v", err)
+				time.Sleep(audioRestartDelay)
+			}
+		}()
+	})
+}
+
+// follows the events reported by pactl subscribe, e.g.
+// "Event 'change' on sink #54", until it exits.
+func followAudioEvents() error {
+	cmd := exec.Command("pactl", "subscribe")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		switch fields[3] {
+		case "sink", "source", "server":
+			atomic.AddUint64(&audioChanges, 1)
+		}
+	}
+
+	return cmd.Wait()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakePactl replaces pactl with a fake one for the duration of a test. It
+// records all calls and answers queries with the given outputs.
+func fakePactl(t *testing.T, outputs map[string]string) *[]string {
+	t.Helper()
+
+	var calls []string
+	orig := pactl
+	pactl = func(args ...string) (string, error) {
+		call := strings.Join(args, " ")
+		calls = append(calls, call)
+
+		if strings.HasPrefix(call, "set-") {
+			return "", nil
+		}
+		out, ok := outputs[call]
+		if !ok {
+			return "", errors.New("no such entity")
+		}
+		return out, nil
+	}
+	t.Cleanup(func() { pactl = orig })
+
+	return &calls
+}
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		output string
+		want   float64
+		err    bool
+	}{
+		{"Volume: front-left: 32768 /  50% / -18.06 dB,   front-right: 32768 /  50% / -18.06 dB\n        balance 0.00\n", 50, false},
+		{"Volume: front-left: 26214 /  40% / -23.88 dB,   front-right: 39322 /  60% / -13.31 dB\n", 50, false},
+		{"Volume: mono: 65536 / 100% / 0.00 dB\n", 100, false},
+		{"Failure: No such entity\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVolume(tt.output)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%q: expected %v (error %t), got %v (%v)", tt.output, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestParseVolumeAdjustment(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		err   bool
+	}{
+		{"+", 55, false},
+		{"-10", 40, false},
+		{"=20", 20, false},
+		{"+80", 100, false},
+		{"-80", 0, false},
+		{"", 0, true},
+		{"*2", 0, true},
+		{"+x", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVolumeAdjustment(tt.value, 50)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%q: expected %v (error %t), got %v (%v)", tt.value, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestAdjustVolume(t *testing.T) {
+	calls := fakePactl(t, map[string]string{
+		"get-sink-volume @DEFAULT_SINK@": "Volume: front-left: 32768 /  50% / -18.06 dB\n",
+		"get-sink-mute @DEFAULT_SINK@":   "Mute: no\n",
+	})
+
+	if err := adjustVolume(VolumeConfig{Adjust: "+5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adjustVolume(VolumeConfig{Device: "source", Name: "mic", Mute: "toggle"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adjustVolume(VolumeConfig{Device: "speaker", Mute: "on"}); err == nil {
+		t.Error("expected error for unknown device")
+	}
+
+	want := []string{
+		"get-sink-volume @DEFAULT_SINK@",
+		"get-sink-mute @DEFAULT_SINK@",
+		"set-sink-volume @DEFAULT_SINK@ 55%",
+		"set-source-mute mic toggle",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("expected calls %q, got %q", want, *calls)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	upowerName   = "org.freedesktop.UPower"
+	upowerPath   = "/org/freedesktop/UPower"
+	upowerDevice = "org.freedesktop.UPower.Device"
+)
+
+// the directory the kernel lists power supplies in. Tests point it to a fake
+// sysfs tree.
+var powerSupplyRoot = "/sys/class/power_supply"
+
+var (
+	// upowerBatteries returns the batteries known to UPower.
+	upowerBatteries = queryUPower
+	// upowerBatteryAt returns the state of the UPower device at a path.
+	upowerBatteryAt = queryUPowerDevice
+)
+
+// errUPowerPending is returned while a battery's state hasn't been queried
+// from UPower yet.
+var errUPowerPending = errors.New("querying UPower")
+
+// battery is the state of a laptop battery or of a device's battery, like a
+// wireless mouse or headset.
+type battery struct {
+	name     string // e.g. BAT0 or hidpp_battery_0
+	model    string
+	system   bool    // powers the computer
+	percent  float64 // charge
+	status   string  // Charging, Discharging, Full, Not charging or Unknown
+	timeLeft time.Duration
+
+	// the object path of batteries found via UPower
+	path dbus.ObjectPath
+}
+
+// charging returns true when the battery is getting charged.
+func (b battery) charging() bool {
+	return b.status == "Charging"
+}
+
+// matches returns true if device names the battery, either by its name or
+// by its model. An empty device matches the system's battery.
+func (b battery) matches(device string) bool {
+	if device == "" {
+		return b.system
+	}
+
+	return b.name == device ||
+		(b.model != "" && strings.Contains(strings.ToLower(b.model), strings.ToLower(device)))
+}
+
+// findSysfsBattery returns the battery named device, or the system's battery
+// if device is empty. It returns false if the kernel doesn't know about the
+// battery, e.g. for ones of bluetooth devices.
+func findSysfsBattery(device string) (battery, bool) {
+	batteries, err := sysfsBatteries()
+	if err != nil {
+		verbosef("Can't read batteries from %s: %s", powerSupplyRoot, err)
+	}
+	for _, b := range batteries {
+		if b.matches(device) {
+			return b, true
+		}
+	}
+
+	return battery{}, false
+}
+
+// findUPowerBattery looks up the battery named device, or the system's
+// battery if device is empty, via UPower. It blocks, so it should run in the
+// background.
+func findUPowerBattery(device string) (battery, error) {
+	batteries, err := upowerBatteries()
+	if err != nil {
+		verbosef("Can't query batteries from UPower: %s", err)
+	}
+	for _, b := range batteries {
+		if b.matches(device) {
+			return b, nil
+		}
+	}
+
+	if device == "" {
+		return battery{}, errors.New("no battery found")
+	}
+	return battery{}, fmt.Errorf("battery %s not found", device)
+}
+
+// upowerWatcher polls the state of a battery from UPower in the background.
+// The battery's object path gets resolved once, and only looked up again when
+// the device disappears.
+type upowerWatcher struct {
+	device string
+
+	mutex   sync.Mutex
+	battery battery
+	err     error
+	dirty   bool
+	done    chan struct{}
+}
+
+// newUPowerWatcher returns a new upowerWatcher and starts polling.
+func newUPowerWatcher(device string, interval time.Duration) *upowerWatcher {
+	u := &upowerWatcher{
+		device: device,
+		err:    errUPowerPending,
+		done:   make(chan struct{}),
+	}
+	go u.run(interval)
+
+	return u
+}
+
+// state returns the last state of the battery.
+func (u *upowerWatcher) state() (battery, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.dirty = false
+	return u.battery, u.err
+}
+
+// updated returns true if the state changed since it was last read.
+func (u *upowerWatcher) updated() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.dirty
+}
+
+// close stops polling.
+func (u *upowerWatcher) close() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	select {
+	case <-u.done:
+	default:
+		close(u.done)
+	}
+}
+
+func (u *upowerWatcher) run(interval time.Duration) {
+	var path dbus.ObjectPath
+	for {
+		b, err := u.poll(&path)
+
+		u.mutex.Lock()
+		u.battery, u.err, u.dirty = b, err, true
+		u.mutex.Unlock()
+
+		select {
+		case <-u.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// queries the battery, resolving its object path if it's unknown.
+func (u *upowerWatcher) poll(path *dbus.ObjectPath) (battery, error) {
+	if *path != "" {
+		b, err := upowerBatteryAt(*path)
+		if err == nil && b.path != "" {
+			return b, nil
+		}
+		if err != nil {
+			verbosef("Can't query UPower device %s: %s", *path, err)
+		}
+	}
+
+	b, err := findUPowerBattery(u.device)
+	*path = b.path
+	return b, err
+}
+
+// sysfsBatteries returns the batteries in powerSupplyRoot, sorted by name.
+func sysfsBatteries() ([]battery, error) {
+	entries, err := ioutil.ReadDir(powerSupplyRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var batteries []battery
+	for _, e := range entries {
+		dir := filepath.Join(powerSupplyRoot, e.Name())
+		if readSysfs(dir, "type") != "Battery" || readSysfs(dir, "present") == "0" {
+			continue
+		}
+
+		b, err := readSysfsBattery(dir)
+		if err != nil {
+			verbosef("Can't read battery %s: %s", e.Name(), err)
+			continue
+		}
+		batteries = append(batteries, b)
+	}
+
+	sort.Slice(batteries, func(i, j int) bool {
+		return batteries[i].name < batteries[j].name
+	})
+	return batteries, nil
+}
+
+// reads the state of the battery in dir.
+func readSysfsBattery(dir string) (battery, error) {
+	b := battery{
+		name: filepath.Base(dir),
+		// batteries of peripherals have the scope "Device"
+		system: readSysfs(dir, "scope") != "Device",
+		model:  readSysfs(dir, "model_name"),
+		status: readSysfs(dir, "status"),
+	}
+	if b.status == "" {
+		b.status = "Unknown"
+	}
+
+	// batteries report either their energy in µWh and power in µW, or their
+	// charge in µAh and current in µA
+	now, full, rate := readSysfsFloat(dir, "energy_now"), readSysfsFloat(dir, "energy_full"), readSysfsFloat(dir, "power_now")
+	if now == 0 && full == 0 {
+		now, full, rate = readSysfsFloat(dir, "charge_now"), readSysfsFloat(dir, "charge_full"), readSysfsFloat(dir, "current_now")
+	}
+
+	if v := readSysfs(dir, "capacity"); v != "" {
+		percent, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return battery{}, fmt.Errorf("invalid capacity %s", v)
+		}
+		b.percent = percent
+	} else if full > 0 {
+		b.percent = now / full * 100
+	} else {
+		return battery{}, errors.New("unknown capacity")
+	}
+
+	switch {
+	case b.status == "Discharging" && readSysfs(dir, "time_to_empty_now") != "":
+		b.timeLeft = time.Duration(readSysfsFloat(dir, "time_to_empty_now")) * time.Second
+	case b.charging() && readSysfs(dir, "time_to_full_now") != "":
+		b.timeLeft = time.Duration(readSysfsFloat(dir, "time_to_full_now")) * time.Second
+	case b.status == "Discharging" && rate > 0:
+		b.timeLeft = time.Duration(now / rate * float64(time.Hour))
+	case b.charging() && rate > 0 && full > now:
+		b.timeLeft = time.Duration((full - now) / rate * float64(time.Hour))
+	}
+
+	return b, nil
+}
+
+// returns the content of a sysfs attribute, or an empty string if it's
+// missing.
+func readSysfs(dir, attr string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			verbosef("Can't read %s: %s", filepath.Join(dir, attr), err)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// returns the numeric value of a sysfs attribute, or 0 if it's missing.
+func readSysfsFloat(dir, attr string) float64 {
+	v, _ := strconv.ParseFloat(readSysfs(dir, attr), 64)
+	return v
+}
+
+// queryUPower returns the batteries known to UPower on the system bus.
+func queryUPower() ([]battery, error) {
+	conn, err := busConn("system")
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []dbus.ObjectPath
+	if err := conn.Object(upowerName, upowerPath).
+		Call(upowerName+".EnumerateDevices", 0).
+		Store(&paths); err != nil {
+		return nil, err
+	}
+
+	var batteries []battery
+	for _, path := range paths {
+		b, err := queryUPowerDevice(path)
+		if err != nil {
+			verbosef("Can't query UPower device %s: %s", path, err)
+			continue
+		}
+		if b.path != "" {
+			batteries = append(batteries, b)
+		}
+	}
+
+	return batteries, nil
+}
+
+// queryUPowerDevice returns the state of the UPower device at path. Its path
+// is empty if the device isn't a battery.
+func queryUPowerDevice(path dbus.ObjectPath) (battery, error) {
+	conn, err := busConn("system")
+	if err != nil {
+		return battery{}, err
+	}
+
+	var props map[string]dbus.Variant
+	if err := conn.Object(upowerName, path).
+		Call("org.freedesktop.DBus.Properties.GetAll", 0, upowerDevice).
+		Store(&props); err != nil {
+		return battery{}, err
+	}
+
+	b, ok := upowerBattery(props)
+	if !ok {
+		return battery{}, nil
+	}
+	b.path = path
+	return b, nil
+}
+
+// converts the properties of a UPower device to a battery. It returns false
+// if the device isn't a battery.
+func upowerBattery(props map[string]dbus.Variant) (battery, bool) {
+	var b battery
+	var kind, state uint32
+	var present, powerSupply bool
+	var timeToEmpty, timeToFull int64
+
+	values := map[string]interface{}{
+		"NativePath":  &b.name,
+		"Model":       &b.model,
+		"Percentage":  &b.percent,
+		"Type":        &kind,
+		"State":       &state,
+		"IsPresent":   &present,
+		"PowerSupply": &powerSupply,
+		"TimeToEmpty": &timeToEmpty,
+		"TimeToFull":  &timeToFull,
+	}
+	for k, v := range values {
+		if p, ok := props[k]; ok {
+			_ = dbus.Store([]interface{}{p.Value()}, v)
+		}
+	}
+
+	// type 1 is a line power supply, 0 is unknown
+	if kind <= 1 || !present {
+		return battery{}, false
+	}
+
+	// type 2 is a battery
+	b.system = kind == 2 && powerSupply
+	switch state {
+	case 1:
+		b.status = "Charging"
+		b.timeLeft = time.Duration(timeToFull) * time.Second
+	case 2, 3:
+		b.status = "Discharging"
+		b.timeLeft = time.Duration(timeToEmpty) * time.Second
+	case 4:
+		b.status = "Full"
+	case 5, 6:
+		b.status = "Not charging"
+	default:
+		b.status = "Unknown"
+	}
+
+	return b, true
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// fakePowerSupplies points powerSupplyRoot to a fake sysfs tree containing the
+// given power supplies and their attributes, and replaces UPower by one
+// knowing the given batteries.
+func fakePowerSupplies(t *testing.T, supplies map[string]map[string]string, upower ...battery) {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, attrs := range supplies {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		for attr, value := range attrs {
+			if err := ioutil.WriteFile(filepath.Join(dir, attr), []byte(value+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	origRoot, origUPower, origUPowerAt := powerSupplyRoot, upowerBatteries, upowerBatteryAt
+	powerSupplyRoot = root
+	upowerBatteries = func() ([]battery, error) {
+		if upower == nil {
+			return nil, errors.New("upower is not available")
+		}
+		return upower, nil
+	}
+	upowerBatteryAt = func(path dbus.ObjectPath) (battery, error) {
+		for _, b := range upower {
+			if b.path == path {
+				return b, nil
+			}
+		}
+		return battery{}, errors.New("no such device")
+	}
+	t.Cleanup(func() {
+		powerSupplyRoot, upowerBatteries, upowerBatteryAt = origRoot, origUPower, origUPowerAt
+	})
+}
+
+// power supplies of a laptop running on battery with a wireless mouse.
+var testPowerSupplies = map[string]map[string]string{
+	"AC": {
+		"type":   "Mains",
+		"online": "0",
+	},
+	"BAT0": {
+		"type":        "Battery",
+		"present":     "1",
+		"status":      "Discharging",
+		"capacity":    "64",
+		"energy_now":  "32000000",
+		"energy_full": "50000000",
+		"power_now":   "8000000",
+		"model_name":  "5B10W13930",
+	},
+	"hidpp_battery_0": {
+		"type":       "Battery",
+		"scope":      "Device",
+		"status":     "Charging",
+		"capacity":   "25",
+		"model_name": "MX Master 3",
+	},
+}
+
+// a bluetooth headset only known to UPower.
+var testHeadset = battery{
+	name:    "/org/bluez/hci0/dev_00_11_22_33_44_55",
+	model:   "WH-1000XM4",
+	percent: 80,
+	status:  "Discharging",
+	path:    "/org/freedesktop/UPower/devices/headset_dev_00_11_22_33_44_55",
+}
+
+func TestFindBattery(t *testing.T) {
+	fakePowerSupplies(t, testPowerSupplies, testHeadset)
+
+	tests := []struct {
+		device string
+		want   battery
+	}{
+		{"", battery{name: "BAT0", model: "5B10W13930", system: true, percent: 64, status: "Discharging", timeLeft: 4 * time.Hour}},
+		{"hidpp_battery_0", battery{name: "hidpp_battery_0", model: "MX Master 3", percent: 25, status: "Charging"}},
+		{"mx master", battery{name: "hidpp_battery_0", model: "MX Master 3", percent: 25, status: "Charging"}},
+	}
+
+	for _, tt := range tests {
+		got, ok := findSysfsBattery(tt.device)
+		if !ok {
+			t.Errorf("%q: battery not found", tt.device)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.device, tt.want, got)
+		}
+	}
+	if _, ok := findSysfsBattery("WH-1000"); ok {
+		t.Error("expected headset not to be in sysfs")
+	}
+
+	got, err := findUPowerBattery("WH-1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testHeadset {
+		t.Errorf("expected %+v, got %+v", testHeadset, got)
+	}
+	if _, err := findUPowerBattery("BAT1"); err == nil {
+		t.Error("expected error for missing battery")
+	}
+}
+
+func TestUPowerWatcher(t *testing.T) {
+	headset := testHeadset
+	fakePowerSupplies(t, nil, headset)
+
+	// count the lookups of the battery's path
+	var lookups int
+	enumerate := upowerBatteries
+	upowerBatteries = func() ([]battery, error) {
+		lookups++
+		return enumerate()
+	}
+
+	u := newUPowerWatcher("WH-1000", 10*time.Millisecond)
+	defer u.close()
+
+	// wait for a few polls
+	deadline := time.Now().Add(5 * time.Second)
+	for polls := 0; polls < 3; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the battery to be polled")
+		}
+		if u.updated() {
+			polls++
+			b, err := u.state()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != headset {
+				t.Fatalf("expected %+v, got %+v", headset, b)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	u.close()
+	time.Sleep(20 * time.Millisecond)
+	if lookups != 1 {
+		t.Errorf("expected the battery to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func TestReadSysfsBattery(t *testing.T) {
+	fakePowerSupplies(t, map[string]map[string]string{
+		// reports its charge instead of its energy
+		"BAT0": {
+			"type":        "Battery",
+			"status":      "Charging",
+			"charge_now":  "1500000",
+			"charge_full": "3000000",
+			"current_now": "1000000",
+		},
+		"BAT1": {
+			"type":              "Battery",
+			"status":            "Discharging",
+			"capacity":          "50",
+			"time_to_empty_now": "5400",
+		},
+		"BAT2": {
+			"type":    "Battery",
+			"present": "0",
+		},
+	})
+
+	batteries, err := sysfsBatteries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []battery{
+		{name: "BAT0", system: true, percent: 50, status: "Charging", timeLeft: 90 * time.Minute},
+		{name: "BAT1", system: true, percent: 50, status: "Discharging", timeLeft: 90 * time.Minute},
+	}
+	if len(batteries) != len(want) {
+		t.Fatalf("expected %d batteries, got %+v", len(want), batteries)
+	}
+	for i := range want {
+		if batteries[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], batteries[i])
+		}
+	}
+}
+
+func TestUPowerBattery(t *testing.T) {
+	b, ok := upowerBattery(map[string]dbus.Variant{
+		"NativePath":  dbus.MakeVariant("/org/bluez/hci0/dev_00_11_22_33_44_55"),
+		"Model":       dbus.MakeVariant("WH-1000XM4"),
+		"Type":        dbus.MakeVariant(uint32(17)),
+		"State":       dbus.MakeVariant(uint32(2)),
+		"IsPresent":   dbus.MakeVariant(true),
+		"PowerSupply": dbus.MakeVariant(false),
+		"Percentage":  dbus.MakeVariant(80.0),
+		"TimeToEmpty": dbus.MakeVariant(int64(3600)),
+	})
+	if !ok {
+		t.Fatal("expected a battery")
+	}
+
+	want := battery{
+		name:     "/org/bluez/hci0/dev_00_11_22_33_44_55",
+		model:    "WH-1000XM4",
+		percent:  80,
+		status:   "Discharging",
+		timeLeft: time.Hour,
+	}
+	if b != want {
+		t.Errorf("expected %+v, got %+v", want, b)
+	}
+
+	if _, ok := upowerBattery(map[string]dbus.Variant{
+		"Type":      dbus.MakeVariant(uint32(1)),
+		"IsPresent": dbus.MakeVariant(true),
+	}); ok {
+		t.Error("expected line power not to be a battery")
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"image/color"
 	"io/ioutil"
@@ -17,20 +16,38 @@ import (
 
 // DBusConfig describes a dbus action.
 type DBusConfig struct {
-	Object string `toml:"object,omitempty"`
-	Path   string `toml:"path,omitempty"`
-	Method string `toml:"method,omitempty"`
-	Value  string `toml:"value,omitempty"`
+	Bus       string   `toml:"bus,omitempty" json:"bus,omitempty"`
+	Object    string   `toml:"object,omitempty" json:"object,omitempty"`
+	Path      string   `toml:"path,omitempty" json:"path,omitempty"`
+	Method    string   `toml:"method,omitempty" json:"method,omitempty"`
+	Property  string   `toml:"property,omitempty" json:"property,omitempty"`
+	Value     string   `toml:"value,omitempty" json:"value,omitempty"`
+	Signature string   `toml:"signature,omitempty" json:"signature,omitempty"`
+	Args      []string `toml:"args,omitempty" json:"args,omitempty"`
+	Label     string   `toml:"label,omitempty" json:"label,omitempty"`
+}
+
+// VolumeConfig describes an action changing the volume of an audio device.
+type VolumeConfig struct {
+	Device string `toml:"device,omitempty" json:"device,omitempty"`
+	Name   string `toml:"name,omitempty" json:"name,omitempty"`
+	Adjust string `toml:"adjust,omitempty" json:"adjust,omitempty"`
+	Mute   string `toml:"mute,omitempty" json:"mute,omitempty"`
 }
 
 // ActionConfig describes an action that can be triggered.
 type ActionConfig struct {
-	Deck    string     `toml:"deck,omitempty"`
-	Keycode string     `toml:"keycode,omitempty"`
-	Exec    string     `toml:"exec,omitempty"`
-	Paste   string     `toml:"paste,omitempty"`
-	Device  string     `toml:"device,omitempty"`
-	DBus    DBusConfig `toml:"dbus,omitempty"`
+	Deck    string        `toml:"deck,omitempty" json:"deck,omitempty"`
+	Keycode string        `toml:"keycode,omitempty" json:"keycode,omitempty"`
+	Exec    string        `toml:"exec,omitempty" json:"exec,omitempty"`
+	Paste   string        `toml:"paste,omitempty" json:"paste,omitempty"`
+	Device  string        `toml:"device,omitempty" json:"device,omitempty"`
+	DBus    DBusConfig    `toml:"dbus,omitempty" json:"dbus,omitempty"`
+	Volume  *VolumeConfig `toml:"volume,omitempty" json:"volume,omitempty"`
+
+	Wait   string         `toml:"wait,omitempty" json:"wait,omitempty"`
+	Repeat int            `toml:"repeat,omitempty" json:"repeat,omitempty"`
+	Steps  []ActionConfig `toml:"steps,omitempty" json:"steps,omitempty"`
 }
 
 // WidgetConfig describes configuration data for widgets.
@@ -42,20 +59,63 @@ type WidgetConfig struct {
 
 // KeyConfig holds the entire configuration for a single key.
 type KeyConfig struct {
-	Index      uint8         `toml:"index"`
-	Widget     WidgetConfig  `toml:"widget"`
-	Action     *ActionConfig `toml:"action,omitempty"`
-	ActionHold *ActionConfig `toml:"action_hold,omitempty"`
+	Index         uint8         `toml:"index"`
+	Widget        WidgetConfig  `toml:"widget"`
+	Action        *ActionConfig `toml:"action,omitempty"`
+	ActionHold    *ActionConfig `toml:"action_hold,omitempty"`
+	ActionDouble  *ActionConfig `toml:"action_double,omitempty"`
+	ActionRelease *ActionConfig `toml:"action_release,omitempty"`
+	HoldTime      uint          `toml:"hold_time,omitempty"`
+	Repeat        *RepeatConfig `toml:"repeat,omitempty"`
+}
+
+// RepeatConfig describes how a key repeats its action while being held.
+type RepeatConfig struct {
+	Interval uint `toml:"interval,omitempty"`
+	Delay    uint `toml:"delay,omitempty"`
 }
 
 // Keys is a slice of keys.
 type Keys []KeyConfig
 
+// ChordConfig describes an action triggered by pressing several keys
+// together.
+type ChordConfig struct {
+	Keys   []uint8       `toml:"keys"`
+	Window uint          `toml:"window,omitempty"`
+	Action *ActionConfig `toml:"action,omitempty"`
+}
+
+// RuleConfig describes a deck to switch to while a matching window is
+// focused.
+type RuleConfig struct {
+	Class string `toml:"class,omitempty"`
+	Name  string `toml:"name,omitempty"`
+	Deck  string `toml:"deck"`
+}
+
+// SignalConfig describes an action triggered by a D-Bus signal.
+type SignalConfig struct {
+	Bus       string        `toml:"bus,omitempty"`
+	Sender    string        `toml:"sender,omitempty"`
+	Path      string        `toml:"path,omitempty"`
+	Interface string        `toml:"interface,omitempty"`
+	Member    string        `toml:"member,omitempty"`
+	Action    *ActionConfig `toml:"action,omitempty"`
+}
+
 // DeckConfig is the central configuration struct.
 type DeckConfig struct {
-	Background string `toml:"background,omitempty"`
-	Parent     string `toml:"parent,omitempty"`
-	Keys       Keys   `toml:"keys"`
+	Background string         `toml:"background,omitempty"`
+	Parent     string         `toml:"parent,omitempty"`
+	HoldTime   uint           `toml:"hold_time,omitempty"`
+	Keys       Keys           `toml:"keys"`
+	Chords     []ChordConfig  `toml:"chords,omitempty"`
+	Rules      []RuleConfig   `toml:"rules,omitempty"`
+	OnSignal   []SignalConfig `toml:"on_signal,omitempty"`
+
+	// files the config was loaded from, including all its parents
+	files []string
 }
 
 // MergeDeckConfig merges key configuration from multiple configs.
@@ -77,7 +137,58 @@ func MergeDeckConfig(base, parent *DeckConfig) DeckConfig {
 	if background == "" {
 		background = parent.Background
 	}
-	return DeckConfig{background, base.Parent, keys}
+	holdTime := base.HoldTime
+	if holdTime == 0 {
+		holdTime = parent.HoldTime
+	}
+
+	// chords of the base config replace chords of the parent with the same keys
+	chords := append([]ChordConfig{}, base.Chords...)
+	for _, pc := range parent.Chords {
+		replaced := false
+		for _, bc := range base.Chords {
+			if sameKeys(pc.Keys, bc.Keys) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			chords = append(chords, pc)
+		}
+	}
+
+	return DeckConfig{
+		Background: background,
+		Parent:     base.Parent,
+		HoldTime:   holdTime,
+		Keys:       keys,
+		Chords:     chords,
+		Rules:      append(append([]RuleConfig{}, base.Rules...), parent.Rules...),
+		OnSignal:   append(append([]SignalConfig{}, base.OnSignal...), parent.OnSignal...),
+		files:      append(append([]string{}, base.files...), parent.files...),
+	}
+}
+
+// returns true if a and b contain the same keys, regardless of their order.
+func sameKeys(a, b []uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, k := range a {
+		found := false
+		for _, l := range b {
+			if k == l {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
 
 // LoadConfigFromFile loads a DeckConfig from a file while checking for circular
@@ -92,9 +203,9 @@ func LoadConfigFromFile(base, path string, files []string) (DeckConfig, error) {
 
 	// check for circular dependencies
 	for _, prev := range files {
-		// TODO: improve error message with actual file names
 		if prev == filename {
-			return config, errors.New("circular reference")
+			return config, fmt.Errorf("circular reference: %s -> %s",
+				strings.Join(files, " -> "), filename)
 		}
 	}
 
@@ -103,18 +214,20 @@ func LoadConfigFromFile(base, path string, files []string) (DeckConfig, error) {
 		return config, err
 	}
 
-	_, err = toml.Decode(string(file), &config)
+	if _, err := toml.Decode(string(file), &config); err != nil {
+		return config, fmt.Errorf("%s: %w", filename, err)
+	}
+	config.files = []string{filename}
 	if config.Parent != "" {
 		parent, err := LoadConfigFromFile(base, config.Parent, append(files, filename))
 		if err != nil {
 			return parent, err
 		}
 
-		merged := MergeDeckConfig(&config, &parent)
-		return merged, err
+		return MergeDeckConfig(&config, &parent), nil
 	}
 
-	return config, err
+	return config, nil
 }
 
 // LoadConfig loads config from filename.
@@ -152,7 +265,10 @@ func ConfigValue(v interface{}, dst interface{}) error {
 		case bool:
 			*d = vt
 		case string:
-			b, _ := strconv.ParseBool(vt)
+			b, err := strconv.ParseBool(vt)
+			if err != nil {
+				return fmt.Errorf("can't convert %q to bool", vt)
+			}
 			*d = b
 		case int64:
 			*d = vt > 0
@@ -167,10 +283,13 @@ func ConfigValue(v interface{}, dst interface{}) error {
 		case float64:
 			*d = int64(vt)
 		case string:
-			x, _ := strconv.ParseInt(vt, 0, 64)
+			x, err := strconv.ParseInt(vt, 0, 64)
+			if err != nil {
+				return fmt.Errorf("can't convert %q to int64", vt)
+			}
 			*d = x
 		default:
-			return fmt.Errorf("unhandled type %+v for uint8 conversion", reflect.TypeOf(vt))
+			return fmt.Errorf("unhandled type %+v for int64 conversion", reflect.TypeOf(vt))
 		}
 
 	case *float64:
@@ -180,7 +299,10 @@ func ConfigValue(v interface{}, dst interface{}) error {
 		case float64:
 			*d = vt
 		case string:
-			x, _ := strconv.ParseFloat(vt, 64)
+			x, err := strconv.ParseFloat(vt, 64)
+			if err != nil {
+				return fmt.Errorf("can't convert %q to float64", vt)
+			}
 			*d = x
 		default:
 			return fmt.Errorf("unhandled type %+v for float64 conversion", reflect.TypeOf(vt))
@@ -189,7 +311,10 @@ func ConfigValue(v interface{}, dst interface{}) error {
 	case *color.Color:
 		switch vt := v.(type) {
 		case string:
-			x, _ := colorful.Hex(vt)
+			x, err := colorful.Hex(vt)
+			if err != nil {
+				return fmt.Errorf("invalid color %q", vt)
+			}
 			*d = x
 		default:
 			return fmt.Errorf("unhandled type %+v for color.Color conversion", reflect.TypeOf(vt))
@@ -209,7 +334,10 @@ func ConfigValue(v interface{}, dst interface{}) error {
 			cls := strings.Split(vt, ";")
 			var clrs []color.Color
 			for _, cl := range cls {
-				clr, _ := colorful.Hex(cl)
+				clr, err := colorful.Hex(cl)
+				if err != nil {
+					return fmt.Errorf("invalid color %q", cl)
+				}
 				clrs = append(clrs, clr)
 			}
 			*d = clrs
@@ -217,6 +345,27 @@ func ConfigValue(v interface{}, dst interface{}) error {
 			return fmt.Errorf("unhandled type %+v for []color.Color conversion", reflect.TypeOf(vt))
 		}
 
+	case *ActionConfig:
+		switch vt := v.(type) {
+		case map[string]interface{}:
+			// round-trip through TOML to decode the table into an ActionConfig
+			var buf bytes.Buffer
+			if err := toml.NewEncoder(&buf).Encode(vt); err != nil {
+				return err
+			}
+			var a ActionConfig
+			md, err := toml.Decode(buf.String(), &a)
+			if err != nil {
+				return err
+			}
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				return fmt.Errorf("unknown action setting %s", undecoded[0])
+			}
+			*d = a
+		default:
+			return fmt.Errorf("unhandled type %+v for action conversion", reflect.TypeOf(vt))
+		}
+
 	default:
 		return fmt.Errorf("unhandled dst type %+v", reflect.TypeOf(dst))
 	}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ControlRequest is a request sent to the control socket, encoded as a single
+// line of JSON.
+type ControlRequest struct {
+	Command string `json:"command"`
+
+	Deck    string `json:"deck,omitempty"`
+	Key     *uint8 `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Icon    string `json:"icon,omitempty"`
+	Color   string `json:"color,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ControlResponse is the reply to a ControlRequest, encoded as a single line
+// of JSON.
+type ControlResponse struct {
+	OK    bool       `json:"ok"`
+	Error string     `json:"error,omitempty"`
+	Deck  *DeckState `json:"deck,omitempty"`
+}
+
+// DeckState describes the current deck and the state of its keys.
+type DeckState struct {
+	File       string     `json:"file"`
+	History    []string   `json:"history,omitempty"`
+	Brightness uint       `json:"brightness"`
+	Asleep     bool       `json:"asleep"`
+	Pinned     bool       `json:"pinned,omitempty"`
+	Keys       []KeyState `json:"keys"`
+}
+
+// KeyState describes the state of a single key.
+type KeyState struct {
+	Index         uint8         `json:"index"`
+	Widget        string        `json:"widget,omitempty"`
+	Pressed       bool          `json:"pressed"`
+	Override      bool          `json:"override,omitempty"`
+	Action        *ActionConfig `json:"action,omitempty"`
+	ActionHold    *ActionConfig `json:"action_hold,omitempty"`
+	ActionDouble  *ActionConfig `json:"action_double,omitempty"`
+	ActionRelease *ActionConfig `json:"action_release,omitempty"`
+}
+
+// controlCall is a ControlRequest waiting to be handled by the event loop.
+type controlCall struct {
+	req   ControlRequest
+	reply chan ControlResponse
+}
+
+// returns the default path of the control socket.
+func defaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("deckmaster-%d.sock", os.Getuid()))
+	}
+
+	return filepath.Join(dir, "deckmaster.sock")
+}
+
+// listenControl listens for control requests on a Unix domain socket and
+// forwards them to ch.
+func listenControl(path string, ch chan<- controlCall) (net.Listener, error) {
+	// remove a stale socket, unless another instance is still listening on it
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Fprintf(os.Stderr, "Control socket failed: %s\n", err)
+				}
+				return
+			}
+
+			go serveControl(conn, ch)
+		}
+	}()
+
+	return l, nil
+}
+
+// handles all requests of a single connection.
+func serveControl(conn net.Conn, ch chan<- controlCall) {
+	defer conn.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var res ControlResponse
+
+		var req ControlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			res.Error = fmt.Sprintf("invalid request: %s", err)
+		} else {
+			call := controlCall{
+				req:   req,
+				reply: make(chan ControlResponse, 1),
+			}
+			ch <- call
+			res = <-call.reply
+		}
+
+		if err := enc.Encode(res); err != nil {
+			return
+		}
+	}
+}
+
+// handleControl executes a control request. It must only be called from the
+// event loop.
+func handleControl(dev Device, req ControlRequest, keys *keyTracker) ControlResponse {
+	verbosef("Received control request: %s", req.Command)
+
+	if err := executeControl(dev, req); err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+
+	res := ControlResponse{OK: true}
+	if req.Command == "dump" {
+		res.Deck = dumpDeck(dev, keys)
+	}
+	return res
+}
+
+func executeControl(dev Device, req ControlRequest) error {
+	needsKey := func() (uint8, error) {
+		if req.Key == nil {
+			return 0, fmt.Errorf("%s requires a key", req.Command)
+		}
+		if *req.Key >= dev.Keys() {
+			return 0, fmt.Errorf("key index %d out of range", *req.Key)
+		}
+		return *req.Key, nil
+	}
+
+	switch req.Command {
+	case "deck":
+		if req.Deck == "" {
+			return errors.New("deck requires a deck file")
+		}
+		return navigateDeck(dev, filepath.Dir(deck.File), req.Deck)
+
+	case "press", "hold":
+		// only triggers the action or hold action, rather than simulating a
+		// physical key, so there are no releases, repeats or chords
+		key, err := needsKey()
+		if err != nil {
+			return err
+		}
+		deck.triggerAction(dev, key, req.Command == "hold")
+
+	case "brightness":
+		if _, err := parseBrightness(req.Value, int64(*brightness)); err != nil {
+			return err
+		}
+		deck.adjustBrightness(dev, req.Value)
+
+	case "sleep":
+		return dev.Sleep()
+
+	case "wake":
+		return dev.Wake()
+
+	case "reload":
+		return reloadDeck(dev)
+
+	case "pin":
+		setPinned(true)
+
+	case "unpin":
+		setPinned(false)
+
+	case "dump":
+
+	case "override":
+		key, err := needsKey()
+		if err != nil {
+			return err
+		}
+
+		var timeout time.Duration
+		if req.Timeout != "" {
+			if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+				return err
+			}
+		}
+
+		opts := WidgetConfig{
+			Config: make(map[string]interface{}),
+		}
+		if req.Label != "" {
+			opts.Config["label"] = req.Label
+		}
+		if req.Icon != "" {
+			opts.Config["icon"] = req.Icon
+		}
+		if req.Color != "" {
+			opts.Config["color"] = req.Color
+			if err := checkConfigValue("", configColor, req.Color); err != nil {
+				return err
+			}
+		}
+		return deck.overrideKey(dev, key, opts, timeout)
+
+	case "clear":
+		key, err := needsKey()
+		if err != nil {
+			return err
+		}
+		return deck.clearOverride(dev, key)
+
+	default:
+		return fmt.Errorf("unknown command %s", req.Command)
+	}
+
+	return nil
+}
+
+// returns the state of the current deck.
+func dumpDeck(dev Device, keys *keyTracker) *DeckState {
+	ds := &DeckState{
+		File:       deck.File,
+		History:    deckHistory,
+		Brightness: *brightness,
+		Asleep:     dev.Asleep(),
+		Pinned:     pinned,
+	}
+
+	for _, w := range deck.Widgets {
+		ks := KeyState{
+			Index:         w.Key(),
+			Widget:        deck.keys[w.Key()].Widget.ID,
+			Pressed:       keys.Pressed(w.Key()),
+			Action:        w.Action(),
+			ActionHold:    w.ActionHold(),
+			ActionDouble:  deck.keys[w.Key()].ActionDouble,
+			ActionRelease: deck.keys[w.Key()].ActionRelease,
+		}
+		if _, ok := w.(*OverrideWidget); ok {
+			ks.Override = true
+		}
+
+		ds.Keys = append(ds.Keys, ks)
+	}
+
+	return ds
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deckmaster.sock")
+	ch := make(chan controlCall)
+
+	l, err := listenControl(path, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+
+	// a second instance must not steal the socket
+	if _, err := listenControl(path, ch); err == nil {
+		t.Fatal("expected socket to be in use")
+	}
+
+	go func() {
+		call := <-ch
+		if call.req.Command != "press" || call.req.Key == nil || *call.req.Key != 3 {
+			call.reply <- ControlResponse{Error: "unexpected request"}
+			return
+		}
+		call.reply <- ControlResponse{OK: true}
+	}()
+
+	req, err := parseCtlArgs([]string{"press", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := sendControl(path, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK {
+		t.Errorf("expected OK response, got error: %s", res.Error)
+	}
+}
+
+func TestParseCtlArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"dump"}, true},
+		{[]string{"brightness", "+10"}, true},
+		{[]string{"override", "2"}, true},
+		{[]string{}, false},
+		{[]string{"dump", "now"}, false},
+		{[]string{"press"}, false},
+		{[]string{"press", "300"}, false},
+		{[]string{"explode"}, false},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseCtlArgs(tt.args); (err == nil) != tt.valid {
+			t.Errorf("%v: expected valid=%t, got error %v", tt.args, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+const ctlUsage = `Usage: deckmaster ctl [flags] <command> [arguments]
+
+Commands:
+  deck <file|@back|@home>     switch to another deck
+  press <key>                 trigger a key's action
+  hold <key>                  trigger a key's hold action
+  brightness <value>          adjust the brightness, e.g. +10, -10 or =50
+  sleep                       put the device to sleep
+  wake                        wake the device up
+  reload                      reload the current deck
+  pin                         stop switching decks automatically
+  unpin                       resume switching decks automatically
+  dump                        print the current deck and key states as JSON
+  override <key> [flags]      temporarily change a key's label, icon or color
+  clear <key>                 remove an override
+
+Flags:
+`
+
+// ctlCommand implements the ctl sub-command. It returns the exit code.
+func ctlCommand(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+	label := flags.String("label", "", "label of an overridden key")
+	icon := flags.String("icon", "", "icon of an overridden key")
+	clr := flags.String("color", "", "color of an overridden key")
+	timeout := flags.String("timeout", "", "duration after which an override gets removed")
+
+	// allow flags to follow the command and its arguments
+	var positional []string
+	for {
+		_ = flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	req, err := parseCtlArgs(positional)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return 2
+	}
+	if req.Command == "override" {
+		req.Label = *label
+		req.Color = *clr
+		req.Timeout = *timeout
+		if *icon != "" {
+			if req.Icon, err = expandPath(".", *icon); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+	}
+
+	res, err := sendControl(*socket, req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !res.OK {
+		fmt.Fprintln(os.Stderr, res.Error)
+		return 1
+	}
+
+	if res.Deck != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res.Deck); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// converts the arguments of the ctl sub-command to a ControlRequest.
+func parseCtlArgs(args []string) (ControlRequest, error) {
+	if len(args) == 0 {
+		return ControlRequest{}, errors.New("missing command")
+	}
+
+	req := ControlRequest{
+		Command: args[0],
+	}
+	args = args[1:]
+
+	var wantArgs int
+	switch req.Command {
+	case "deck", "press", "hold", "brightness", "override", "clear":
+		wantArgs = 1
+	case "sleep", "wake", "reload", "pin", "unpin", "dump":
+	default:
+		return req, fmt.Errorf("unknown command %s", req.Command)
+	}
+	if len(args) != wantArgs {
+		return req, fmt.Errorf("%s expects %d argument(s)", req.Command, wantArgs)
+	}
+
+	switch req.Command {
+	case "deck":
+		if isDeckTarget(args[0]) {
+			req.Deck = args[0]
+			break
+		}
+
+		// resolve relative paths here, the server doesn't know our directory
+		path, err := expandPath(".", args[0])
+		if err != nil {
+			return req, err
+		}
+		req.Deck = path
+
+	case "brightness":
+		req.Value = args[0]
+
+	case "press", "hold", "override", "clear":
+		key, err := strconv.ParseUint(args[0], 10, 8)
+		if err != nil {
+			return req, fmt.Errorf("invalid key %s", args[0])
+		}
+		k := uint8(key)
+		req.Key = &k
+	}
+
+	return req, nil
+}
+
+// sendControl sends a request to the control socket and waits for the reply.
+func sendControl(path string, req ControlRequest) (ControlResponse, error) {
+	var res ControlResponse
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return res, fmt.Errorf("can't connect to deckmaster: %s", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return res, err
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(line, &res)
+	return res, err
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// how long the reply of a dbus action gets shown on its key
+const dbusReplyTimeout = 2 * time.Second
+
+// SignalEvent gets emitted when a D-Bus signal arrives.
+type SignalEvent struct {
+	Bus    string
+	Signal *dbus.Signal
+}
+
+// signalWidget is implemented by widgets reacting to D-Bus signals.
+type signalWidget interface {
+	Widget
+	Signal(e SignalEvent)
+}
+
+// signalMatch describes the D-Bus signals a widget or action is interested in.
+type signalMatch struct {
+	bus    string
+	sender string
+	path   string
+	iface  string
+	member string
+
+	// the match rules added by subscribe
+	rules []string
+
+	// the unique name currently owning sender. It gets resolved in the
+	// background, unless a NameOwnerChanged signal arrived first.
+	mutex        sync.Mutex
+	owner        string
+	ownerChanged bool
+}
+
+var (
+	// the number of matches using each match rule, by bus and rule
+	signalRules = make(map[string]int)
+	// the last signal received for each match rule
+	lastSignals = make(map[string]SignalEvent)
+)
+
+var (
+	// addMatchRule asks a bus to deliver the signals matching rule.
+	addMatchRule = func(bus, rule string) error {
+		conn, err := busConn(bus)
+		if err != nil {
+			return err
+		}
+		return addMatches(conn, []string{rule})
+	}
+
+	// removeMatchRule asks a bus to stop delivering the signals matching
+	// rule. It doesn't wait for a reply.
+	removeMatchRule = func(bus, rule string) {
+		if conn, err := busConn(bus); err == nil {
+			conn.BusObject().Go("org.freedesktop.DBus.RemoveMatch", dbus.FlagNoReplyExpected, nil, rule)
+		}
+	}
+
+	// nameOwner returns the unique name of the current owner of a
+	// well-known name.
+	nameOwner = func(bus, name string) (string, error) {
+		conn, err := busConn(bus)
+		if err != nil {
+			return "", err
+		}
+
+		var owner string
+		err = conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner)
+		return owner, err
+	}
+)
+
+// busConn returns the connection to a bus, either "session" or "system".
+func busConn(bus string) (*dbus.Conn, error) {
+	switch bus {
+	case "", "session":
+		if dbusConn == nil {
+			return nil, errors.New("dbus support is disabled")
+		}
+		return dbusConn, nil
+
+	case "system":
+		return dbus.SystemBus()
+	}
+
+	return nil, fmt.Errorf("unknown bus %s", bus)
+}
+
+// watchSignals emits SignalEvents to ch for all signals arriving on the
+// session and system bus.
+func watchSignals(ch chan interface{}) {
+	for _, bus := range []string{"session", "system"} {
+		conn, err := busConn(bus)
+		if err != nil {
+			verbosef("Can't watch signals on the %s bus: %s", bus, err)
+			continue
+		}
+
+		sigs := make(chan *dbus.Signal, 64)
+		conn.Signal(sigs)
+
+		go func(bus string) {
+			for sig := range sigs {
+				ch <- SignalEvent{Bus: bus, Signal: sig}
+			}
+		}(bus)
+	}
+}
+
+// newSignalMatch returns a signalMatch for the given bus, sender, object path,
+// interface and signal name. Empty values match everything.
+func newSignalMatch(bus, sender, path, iface, member string) (*signalMatch, error) {
+	switch bus {
+	case "":
+		bus = "session"
+	case "session", "system":
+	default:
+		return nil, fmt.Errorf("unknown bus %s", bus)
+	}
+	if iface == "" && member == "" {
+		return nil, errors.New("signal needs an interface or member")
+	}
+
+	return &signalMatch{
+		bus:    bus,
+		sender: sender,
+		path:   path,
+		iface:  iface,
+		member: member,
+	}, nil
+}
+
+// rule returns the D-Bus match rule for the signal.
+func (m *signalMatch) rule() string {
+	rule := []string{"type='signal'"}
+	for k, v := range map[string]string{
+		"sender":    m.sender,
+		"path":      m.path,
+		"interface": m.iface,
+		"member":    m.member,
+	} {
+		if v != "" {
+			rule = append(rule, fmt.Sprintf("%s='%s'", k, v))
+		}
+	}
+	sort.Strings(rule[1:])
+
+	return strings.Join(rule, ",")
+}
+
+// key identifies the signals matched on their bus.
+func (m *signalMatch) key() string {
+	return m.bus + ":" + m.rule()
+}
+
+// subscribe asks the bus to deliver matching signals. For senders with a
+// well-known name, it also subscribes to changes of their owner, and resolves
+// the current owner in the background.
+func (m *signalMatch) subscribe() error {
+	rules := []string{m.rule()}
+	if m.wellKnown() {
+		rules = append(rules, fmt.Sprintf("type='signal',sender='org.freedesktop.DBus',"+
+			"interface='org.freedesktop.DBus',member='NameOwnerChanged',arg0='%s'", m.sender))
+	}
+
+	for _, rule := range rules {
+		key := m.bus + ":" + rule
+		if signalRules[key] == 0 {
+			if err := addMatchRule(m.bus, rule); err != nil {
+				return err
+			}
+		}
+		signalRules[key]++
+		m.rules = append(m.rules, rule)
+	}
+
+	if m.wellKnown() {
+		go m.resolveOwner()
+	}
+	return nil
+}
+
+// unsubscribe removes the match rules added by subscribe, unless other
+// matches still use them.
+func (m *signalMatch) unsubscribe() {
+	for _, rule := range m.rules {
+		key := m.bus + ":" + rule
+		signalRules[key]--
+		if signalRules[key] > 0 {
+			continue
+		}
+
+		delete(signalRules, key)
+		removeMatchRule(m.bus, rule)
+	}
+	m.rules = nil
+}
+
+// looks up the current owner of the sender.
+func (m *signalMatch) resolveOwner() {
+	owner, err := nameOwner(m.bus, m.sender)
+	if err != nil {
+		// the name doesn't need to be owned yet
+		verbosef("Can't resolve owner of %s: %s", m.sender, err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.ownerChanged {
+		m.owner = owner
+	}
+}
+
+// returns true if the sender is a well-known name rather than a unique one.
+func (m *signalMatch) wellKnown() bool {
+	return m.sender != "" && !strings.HasPrefix(m.sender, ":")
+}
+
+// matches returns true if a signal matches.
+func (m *signalMatch) matches(e SignalEvent) bool {
+	if e.Bus != m.bus {
+		return false
+	}
+
+	// signals carry the unique name of their sender, so the owner of a
+	// well-known name gets tracked as it changes
+	if m.wellKnown() && e.Signal.Sender == "org.freedesktop.DBus" &&
+		e.Signal.Name == "org.freedesktop.DBus.NameOwnerChanged" {
+		var name, oldOwner, newOwner string
+		if err := dbus.Store(e.Signal.Body, &name, &oldOwner, &newOwner); err == nil && name == m.sender {
+			m.mutex.Lock()
+			m.owner, m.ownerChanged = newOwner, true
+			m.mutex.Unlock()
+		}
+	}
+
+	if m.path != "" && string(e.Signal.Path) != m.path {
+		return false
+	}
+
+	iface, member := e.Signal.Name, ""
+	if i := strings.LastIndex(iface, "."); i >= 0 {
+		iface, member = iface[:i], iface[i+1:]
+	}
+	if m.iface != "" && iface != m.iface {
+		return false
+	}
+	if m.member != "" && member != m.member {
+		return false
+	}
+
+	if m.sender == "" || e.Signal.Sender == m.sender {
+		return true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.wellKnown() && m.owner != "" && e.Signal.Sender == m.owner
+}
+
+// signalValue returns the argument at index arg of a signal. For dictionaries,
+// key is the path to the desired value.
+func signalValue(body []interface{}, arg int, key []string) (interface{}, bool) {
+	if arg < 0 || arg >= len(body) {
+		return nil, false
+	}
+
+	return lookupValue(body[arg], key)
+}
+
+// lookupValue follows a path of keys through nested dictionaries.
+func lookupValue(v interface{}, key []string) (interface{}, bool) {
+	for _, k := range key {
+		var ok bool
+		switch m := unwrapVariant(v).(type) {
+		case map[string]dbus.Variant:
+			v, ok = m[k]
+		case map[string]interface{}:
+			v, ok = m[k]
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return unwrapVariant(v), true
+}
+
+func unwrapVariant(v interface{}) interface{} {
+	for {
+		variant, ok := v.(dbus.Variant)
+		if !ok {
+			return v
+		}
+		v = variant.Value()
+	}
+}
+
+// formatValue formats D-Bus values for display.
+func formatValue(format string, values ...interface{}) string {
+	for i, v := range values {
+		v = unwrapVariant(v)
+		if s, ok := v.([]string); ok {
+			v = strings.Join(s, ", ")
+		}
+		values[i] = v
+	}
+	if format == "" {
+		format = strings.TrimSuffix(strings.Repeat("%v ", len(values)), " ")
+	}
+
+	return fmt.Sprintf(format, values...)
+}
+
+// executeDBus calls a dbus method, or gets or sets a property. It returns the
+// reply.
+func executeDBus(c DBusConfig) ([]interface{}, error) {
+	conn, err := busConn(c.Bus)
+	if err != nil {
+		return nil, err
+	}
+	args, err := dbusArgs(c)
+	if err != nil {
+		return nil, err
+	}
+	obj := conn.Object(c.Object, dbus.ObjectPath(c.Path))
+
+	if c.Property == "" {
+		call := obj.Call(c.Method, 0, args...)
+		return call.Body, call.Err
+	}
+
+	if len(args) == 0 {
+		v, err := obj.GetProperty(c.Property)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{v.Value()}, nil
+	}
+
+	i := strings.LastIndex(c.Property, ".")
+	call := obj.Call("org.freedesktop.DBus.Properties.Set", 0,
+		c.Property[:i], c.Property[i+1:], dbus.MakeVariant(args[0]))
+	return nil, call.Err
+}
+
+// dbusArgs returns the typed arguments of a dbus action.
+func dbusArgs(c DBusConfig) ([]interface{}, error) {
+	if c.Property != "" {
+		if !strings.Contains(c.Property, ".") {
+			return nil, fmt.Errorf("property %s needs to include its interface", c.Property)
+		}
+		if len(c.Args) > 1 {
+			return nil, errors.New("setting a property takes a single argument")
+		}
+	}
+
+	if len(c.Args) == 0 {
+		if c.Signature != "" {
+			return nil, fmt.Errorf("signature %s without arguments", c.Signature)
+		}
+
+		// a plain value is always passed as a string
+		if c.Value != "" {
+			return []interface{}{c.Value}, nil
+		}
+		return nil, nil
+	}
+	if c.Value != "" {
+		return nil, errors.New("value and args can't be combined")
+	}
+
+	var types []string
+	if c.Signature == "" {
+		for range c.Args {
+			types = append(types, "s")
+		}
+	} else {
+		if _, err := dbus.ParseSignature(c.Signature); err != nil {
+			return nil, fmt.Errorf("invalid signature %s", c.Signature)
+		}
+		types = splitSignature(c.Signature)
+	}
+	if len(types) != len(c.Args) {
+		return nil, fmt.Errorf("signature %s expects %d arguments, got %d", c.Signature, len(types), len(c.Args))
+	}
+
+	args := make([]interface{}, 0, len(c.Args))
+	for i, s := range c.Args {
+		arg, err := parseArg(types[i], s)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// splits a valid signature into its single complete types.
+func splitSignature(sig string) []string {
+	var types []string
+	for sig != "" {
+		n := completeType(sig)
+		types = append(types, sig[:n])
+		sig = sig[n:]
+	}
+
+	return types
+}
+
+// returns the length of the first complete type of a valid signature.
+func completeType(sig string) int {
+	switch sig[0] {
+	case 'a':
+		return 1 + completeType(sig[1:])
+
+	case '(', '{':
+		n := 1
+		for sig[n] != ')' && sig[n] != '}' {
+			n += completeType(sig[n:])
+		}
+		return n + 1
+	}
+
+	return 1
+}
+
+// parses an argument of a single complete type. Basic types are parsed
+// verbatim, containers use the GVariant text format, e.g. [1, 2, 3].
+func parseArg(sig string, s string) (interface{}, error) {
+	var err error
+	var v interface{}
+
+	switch sig {
+	case "s":
+		return s, nil
+	case "o":
+		if !dbus.ObjectPath(s).IsValid() {
+			return nil, fmt.Errorf("invalid object path %s", s)
+		}
+		return dbus.ObjectPath(s), nil
+	case "g":
+		return dbus.ParseSignature(s)
+	case "b":
+		v, err = strconv.ParseBool(s)
+	case "y":
+		var x uint64
+		x, err = strconv.ParseUint(s, 0, 8)
+		v = byte(x)
+	case "n":
+		var x int64
+		x, err = strconv.ParseInt(s, 0, 16)
+		v = int16(x)
+	case "q":
+		var x uint64
+		x, err = strconv.ParseUint(s, 0, 16)
+		v = uint16(x)
+	case "i":
+		var x int64
+		x, err = strconv.ParseInt(s, 0, 32)
+		v = int32(x)
+	case "u":
+		var x uint64
+		x, err = strconv.ParseUint(s, 0, 32)
+		v = uint32(x)
+	case "x":
+		v, err = strconv.ParseInt(s, 0, 64)
+	case "t":
+		v, err = strconv.ParseUint(s, 0, 64)
+	case "d":
+		v, err = strconv.ParseFloat(s, 64)
+	case "h":
+		return nil, errors.New("file descriptors are not supported")
+	default:
+		var variant dbus.Variant
+		variant, err = dbus.ParseVariant(s, dbus.ParseSignatureMust(sig))
+		v = variant.Value()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't convert %q to %s", s, sig)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+func TestSignalMatch(t *testing.T) {
+	m, err := newSignalMatch("", ":1.7", "/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager", "StateChanged")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := "type='signal',interface='org.freedesktop.NetworkManager',member='StateChanged'," +
+		"path='/org/freedesktop/NetworkManager',sender=':1.7'"
+	if m.rule() != rule {
+		t.Errorf("expected rule %s, got %s", rule, m.rule())
+	}
+
+	signal := func(bus, sender, path, name string) SignalEvent {
+		return SignalEvent{
+			Bus: bus,
+			Signal: &dbus.Signal{
+				Sender: sender,
+				Path:   dbus.ObjectPath(path),
+				Name:   name,
+			},
+		}
+	}
+	tests := []struct {
+		event SignalEvent
+		match bool
+	}{
+		{signal("session", ":1.7", "/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager.StateChanged"), true},
+		{signal("system", ":1.7", "/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager.StateChanged"), false},
+		{signal("session", ":1.8", "/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager.StateChanged"), false},
+		{signal("session", ":1.7", "/org/freedesktop", "org.freedesktop.NetworkManager.StateChanged"), false},
+		{signal("session", ":1.7", "/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager.DeviceAdded"), false},
+		{signal("session", ":1.7", "/org/freedesktop/NetworkManager", "org.freedesktop.Other.StateChanged"), false},
+	}
+	for _, tt := range tests {
+		if m.matches(tt.event) != tt.match {
+			t.Errorf("expected %+v to match: %t", tt.event.Signal, tt.match)
+		}
+	}
+
+	if _, err := newSignalMatch("user", "", "", "", "Changed"); err == nil {
+		t.Error("expected error for unknown bus")
+	}
+	if _, err := newSignalMatch("", "", "/", "", ""); err == nil {
+		t.Error("expected error for signal without interface and member")
+	}
+}
+
+func TestSignalMatchOwner(t *testing.T) {
+	m, err := newSignalMatch("", "org.mpris.MediaPlayer2.spotify", "/org/mpris/MediaPlayer2",
+		"org.freedesktop.DBus.Properties", "PropertiesChanged")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner of well-known names only gets tracked through signals
+	steps := []struct {
+		signal *dbus.Signal
+		match  bool
+	}{
+		{playerChanged(":1.10", "Playing", ""), false},
+		{ownerChanged("org.mpris.MediaPlayer2.spotify", "", ":1.10"), false},
+		{playerChanged(":1.10", "Playing", ""), true},
+		{playerChanged(":1.11", "Playing", ""), false},
+		{ownerChanged("org.mpris.MediaPlayer2.vlc", "", ":1.11"), false},
+		{playerChanged(":1.11", "Playing", ""), false},
+		{ownerChanged("org.mpris.MediaPlayer2.spotify", ":1.10", ""), false},
+		{playerChanged(":1.10", "Playing", ""), false},
+	}
+	for i, s := range steps {
+		if m.matches(SignalEvent{Bus: "session", Signal: s.signal}) != s.match {
+			t.Errorf("step %d: expected %+v to match: %t", i, s.signal, s.match)
+		}
+	}
+}
+
+// fakeBus replaces the bus calls of signal matches. It records the match
+// rules added and removed, and resolves names once release gets closed,
+// counting the lookups.
+func fakeBus(t *testing.T, owners map[string]string, release chan struct{}) (added, removed *[]string, lookups *int32) {
+	t.Helper()
+
+	added, removed, lookups = &[]string{}, &[]string{}, new(int32)
+	origAdd, origRemove, origOwner := addMatchRule, removeMatchRule, nameOwner
+	addMatchRule = func(bus, rule string) error {
+		*added = append(*added, rule)
+		return nil
+	}
+	removeMatchRule = func(bus, rule string) {
+		*removed = append(*removed, rule)
+	}
+	nameOwner = func(bus, name string) (string, error) {
+		<-release
+		defer atomic.AddInt32(lookups, 1)
+		return owners[name], nil
+	}
+	t.Cleanup(func() {
+		addMatchRule, removeMatchRule, nameOwner = origAdd, origRemove, origOwner
+		signalRules = make(map[string]int)
+	})
+
+	return added, removed, lookups
+}
+
+// waits until the owners of n names got looked up.
+func waitForLookups(t *testing.T, lookups *int32, n int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(lookups) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d name lookups", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waits until a match resolved the owner of its sender.
+func waitForOwner(t *testing.T, m *signalMatch, owner string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mutex.Lock()
+		got := m.owner
+		m.mutex.Unlock()
+
+		if got == owner {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for owner %s, got %q", owner, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSignalMatchSubscribe(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	added, removed, lookups := fakeBus(t, map[string]string{"org.example.Player": ":1.5"}, release)
+
+	var matches []*signalMatch
+	for i := 0; i < 2; i++ {
+		m, err := newSignalMatch("", "org.example.Player", "", "org.example.Player", "Changed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.subscribe(); err != nil {
+			t.Fatal(err)
+		}
+		matches = append(matches, m)
+	}
+
+	// matches share their rules, including the one tracking the owner
+	if len(*added) != 2 {
+		t.Errorf("expected rule and owner rule to be added once, got %v", *added)
+	}
+	waitForLookups(t, lookups, 2)
+	waitForOwner(t, matches[0], ":1.5")
+	if !matches[0].matches(SignalEvent{Bus: "session", Signal: &dbus.Signal{Sender: ":1.5", Name: "org.example.Player.Changed"}}) {
+		t.Error("expected signal of the resolved owner to match")
+	}
+
+	// rules get removed once no match uses them anymore
+	matches[0].unsubscribe()
+	if len(*removed) != 0 {
+		t.Errorf("expected rules still in use to be kept, got %v removed", *removed)
+	}
+	matches[1].unsubscribe()
+	if len(*removed) != 2 || len(signalRules) != 0 {
+		t.Errorf("expected both rules to be removed, got %v", *removed)
+	}
+}
+
+func TestSignalMatchOwnerChangedFirst(t *testing.T) {
+	release := make(chan struct{})
+	_, _, lookups := fakeBus(t, map[string]string{"org.example.Player": ":1.5"}, release)
+
+	m, err := newSignalMatch("", "org.example.Player", "", "org.example.Player", "Changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.unsubscribe()
+
+	// an owner change arriving before the lookup finished is more recent
+	m.matches(SignalEvent{Bus: "session", Signal: ownerChanged("org.example.Player", ":1.5", ":1.6")})
+	close(release)
+	waitForLookups(t, lookups, 1)
+
+	waitForOwner(t, m, ":1.6")
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		format string
+		value  interface{}
+		want   string
+	}{
+		{"", uint32(70), "70"},
+		{"%d%%", uint32(70), "70%"},
+		{"%s", []string{"Muse", "Queen"}, "Muse, Queen"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.format, tt.value); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+
+	body := []interface{}{dbus.MakeVariant(map[string]interface{}{"a": dbus.MakeVariant(true)})}
+	if v, ok := signalValue(body, 0, []string{"a"}); !ok || v != true {
+		t.Errorf("expected nested value true, got %v", v)
+	}
+	if _, ok := signalValue(body, 1, nil); ok {
+		t.Error("expected missing argument to be reported")
+	}
+}
+
+func TestDBusArgs(t *testing.T) {
+	tests := []struct {
+		config DBusConfig
+		want   []interface{}
+		err    bool
+	}{
+		{DBusConfig{}, nil, false},
+		{DBusConfig{Value: "play"}, []interface{}{"play"}, false},
+		{DBusConfig{Args: []string{"a", "b"}}, []interface{}{"a", "b"}, false},
+		{
+			DBusConfig{Signature: "ox", Args: []string{"/org/mpris/MediaPlayer2/Track/1", "-5000000"}},
+			[]interface{}{dbus.ObjectPath("/org/mpris/MediaPlayer2/Track/1"), int64(-5000000)},
+			false,
+		},
+		{
+			DBusConfig{Signature: "buyd", Args: []string{"true", "7", "0x10", "0.5"}},
+			[]interface{}{true, uint32(7), byte(16), 0.5},
+			false,
+		},
+		{
+			DBusConfig{Signature: "asa{si}", Args: []string{`["a", "b"]`, `{"x": 1}`}},
+			[]interface{}{[]string{"a", "b"}, map[string]int32{"x": 1}},
+			false,
+		},
+		{DBusConfig{Property: "org.mpris.MediaPlayer2.Player.Volume", Signature: "d", Args: []string{"0.5"}}, []interface{}{0.5}, false},
+		{DBusConfig{Property: "Volume"}, nil, true},
+		{DBusConfig{Property: "org.mpris.MediaPlayer2.Player.Volume", Args: []string{"1", "2"}}, nil, true},
+		{DBusConfig{Signature: "x", Args: []string{"soon"}}, nil, true},
+		{DBusConfig{Signature: "y", Args: []string{"256"}}, nil, true},
+		{DBusConfig{Signature: "o", Args: []string{"no/path"}}, nil, true},
+		{DBusConfig{Signature: "ss", Args: []string{"a"}}, nil, true},
+		{DBusConfig{Signature: "a(", Args: []string{"a"}}, nil, true},
+		{DBusConfig{Signature: "s"}, nil, true},
+		{DBusConfig{Value: "a", Args: []string{"b"}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		args, err := dbusArgs(tt.config)
+		if (err != nil) != tt.err {
+			t.Errorf("%+v: expected error %t, got %v", tt.config, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.want) {
+			t.Errorf("%+v: expected %#v, got %#v", tt.config, tt.want, args)
+		}
+	}
+}
+
+func TestSplitSignature(t *testing.T) {
+	want := []string{"s", "a{sv}", "(ia(sb))", "ax"}
+	if got := splitSignature("sa{sv}(ia(sb))ax"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
@@ -13,19 +14,30 @@ import (
 	"time"
 
 	"github.com/atotto/clipboard"
-	"github.com/godbus/dbus"
-	"github.com/muesli/streamdeck"
 )
 
 // Deck is a set of widgets.
 type Deck struct {
 	File       string
+	Files      []string
 	Background image.Image
 	Widgets    []Widget
+
+	keys     map[uint8]KeyConfig
+	chords   []chord
+	rules    []rule
+	signals  []signalAction
+	holdTime time.Duration
+}
+
+// signalAction is an action triggered by a D-Bus signal.
+type signalAction struct {
+	match  *signalMatch
+	action *ActionConfig
 }
 
 // LoadDeck loads a deck configuration.
-func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
+func LoadDeck(dev Device, base string, deck string) (*Deck, error) {
 	path, err := expandPath(base, deck)
 	if err != nil {
 		return nil, err
@@ -38,7 +50,10 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 	}
 
 	d := Deck{
-		File: path,
+		File:     path,
+		Files:    dc.files,
+		keys:     make(map[uint8]KeyConfig),
+		holdTime: time.Duration(dc.HoldTime) * time.Millisecond,
 	}
 	if dc.Background != "" {
 		bgpath, err := expandPath(filepath.Dir(path), dc.Background)
@@ -48,24 +63,53 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 		if err := d.loadBackground(dev, bgpath); err != nil {
 			return nil, err
 		}
+		d.Files = append(d.Files, bgpath)
 	}
 
-	keyMap := map[uint8]KeyConfig{}
 	for _, k := range dc.Keys {
-		keyMap[k.Index] = k
+		d.keys[k.Index] = k
+		d.Files = append(d.Files, iconFiles(filepath.Dir(path), k.Widget)...)
 	}
 
-	for i := uint8(0); i < dev.Keys; i++ {
-		bg := d.backgroundForKey(dev, i)
+	d.rules, err = compileRules(filepath.Dir(path), dc.Rules)
+	if err != nil {
+		return nil, err
+	}
 
-		var w Widget
-		if k, found := keyMap[i]; found {
-			w, err = NewWidget(dev, filepath.Dir(path), k, bg)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			w = NewBaseWidget(dev, filepath.Dir(path), i, nil, nil, bg)
+	for _, c := range dc.Chords {
+		window := defaultChordWindow
+		if c.Window > 0 {
+			window = time.Duration(c.Window) * time.Millisecond
+		}
+
+		d.chords = append(d.chords, chord{
+			keys:   c.Keys,
+			window: window,
+			action: c.Action,
+		})
+	}
+
+	for _, sc := range dc.OnSignal {
+		m, err := newSignalMatch(sc.Bus, sc.Sender, sc.Path, sc.Interface, sc.Member)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+		if err := m.subscribe(); err != nil {
+			verbosef("Can't subscribe to signal %s: %s", m.rule(), err)
+		}
+
+		d.signals = append(d.signals, signalAction{
+			match:  m,
+			action: sc.Action,
+		})
+	}
+
+	for i := uint8(0); i < dev.Keys(); i++ {
+		w, err := d.newWidget(dev, i)
+		if err != nil {
+			d.close()
+			return nil, err
 		}
 
 		d.Widgets = append(d.Widgets, w)
@@ -74,8 +118,138 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 	return &d, nil
 }
 
+// creates the widget for a key.
+func (d *Deck) newWidget(dev Device, index uint8) (Widget, error) {
+	bg := d.backgroundForKey(dev, index)
+
+	if k, found := d.keys[index]; found {
+		return NewWidget(dev, d.File, k, bg)
+	}
+	return NewBaseWidget(dev, filepath.Dir(d.File), index, nil, nil, bg), nil
+}
+
+// overrideKey temporarily replaces the appearance of a key.
+func (d *Deck) overrideKey(dev Device, index uint8, opts WidgetConfig, timeout time.Duration) error {
+	if int(index) >= len(d.Widgets) {
+		return fmt.Errorf("key index %d out of range", index)
+	}
+
+	w := d.Widgets[index]
+	if ow, ok := w.(*OverrideWidget); ok {
+		w = ow.Widget()
+	}
+
+	bw := NewBaseWidget(dev, "", index, nil, nil, d.backgroundForKey(dev, index))
+	ow, err := NewOverrideWidget(bw, w, opts, timeout)
+	if err != nil {
+		return err
+	}
+
+	d.Widgets[index] = ow
+	return ow.Update()
+}
+
+// clearOverride restores the original appearance of a key.
+func (d *Deck) clearOverride(dev Device, index uint8) error {
+	if int(index) >= len(d.Widgets) {
+		return fmt.Errorf("key index %d out of range", index)
+	}
+	if _, ok := d.Widgets[index].(*OverrideWidget); !ok {
+		return nil
+	}
+
+	// re-create the widget, so it gets repainted from scratch
+	w, err := d.newWidget(dev, index)
+	if err != nil {
+		return err
+	}
+
+	closeWidget(d.Widgets[index])
+	d.Widgets[index] = w
+	return w.Update()
+}
+
+// redraw re-creates and repaints all widgets, e.g. after the device lost its
+// state.
+func (d *Deck) redraw(dev Device) error {
+	if err := dev.Clear(); err != nil {
+		return err
+	}
+
+	for i := range d.Widgets {
+		w, err := d.newWidget(dev, uint8(i))
+		if err != nil {
+			return err
+		}
+
+		closeWidget(d.Widgets[i])
+		d.Widgets[i] = w
+	}
+
+	d.updateWidgets()
+	return nil
+}
+
+// switchDeck loads a deck and makes it the current deck.
+func switchDeck(dev Device, base string, path string) error {
+	d, err := LoadDeck(dev, base, path)
+	if err != nil {
+		return err
+	}
+	if err := dev.Clear(); err != nil {
+		fatal(err)
+		return nil
+	}
+
+	deck.close()
+	deck = d
+	deck.updateWidgets()
+	return nil
+}
+
+// close releases the resources held by the deck and its widgets.
+func (d *Deck) close() {
+	for _, w := range d.Widgets {
+		closeWidget(w)
+	}
+	for _, s := range d.signals {
+		s.match.unsubscribe()
+	}
+}
+
+// closeWidget releases the resources held by a widget, including the one
+// hidden by an override.
+func closeWidget(w Widget) {
+	if ow, ok := w.(*OverrideWidget); ok {
+		w = ow.Widget()
+	}
+	if c, ok := w.(closer); ok {
+		c.Close()
+	}
+}
+
+// returns the paths of all icons a widget config refers to.
+func iconFiles(base string, wc WidgetConfig) []string {
+	var files []string
+	for name, t := range widgetConfigs[wc.ID] {
+		if t != configIcon {
+			continue
+		}
+
+		var icon string
+		if err := ConfigValue(wc.Config[name], &icon); err != nil || icon == "" {
+			continue
+		}
+		if path, err := expandPath(base, icon); err == nil {
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
+
 // loads a background image.
-func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
+func (d *Deck) loadBackground(dev Device, bg string) error {
 	f, err := os.Open(bg)
 	if err != nil {
 		return err
@@ -87,10 +261,10 @@ func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
 		return err
 	}
 
-	rows := int(dev.Rows)
-	cols := int(dev.Columns)
-	padding := int(dev.Padding)
-	pixels := int(dev.Pixels)
+	rows := int(dev.Rows())
+	cols := int(dev.Columns())
+	padding := int(dev.Padding())
+	pixels := int(dev.Pixels())
 
 	width := cols*pixels + (cols-1)*padding
 	height := rows*pixels + (rows-1)*padding
@@ -104,14 +278,14 @@ func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
 }
 
 // returns the background image for an individual key.
-func (d Deck) backgroundForKey(dev *streamdeck.Device, key uint8) image.Image {
-	padding := int(dev.Padding)
-	pixels := int(dev.Pixels)
+func (d Deck) backgroundForKey(dev Device, key uint8) image.Image {
+	padding := int(dev.Padding())
+	pixels := int(dev.Pixels())
 	bg := image.NewRGBA(image.Rect(0, 0, pixels, pixels))
 
 	if d.Background != nil {
-		startx := int(key%dev.Columns) * (pixels + padding)
-		starty := int(key/dev.Columns) * (pixels + padding)
+		startx := int(key%dev.Columns()) * (pixels + padding)
+		starty := int(key/dev.Columns()) * (pixels + padding)
 		draw.Draw(bg, bg.Bounds(), d.Background, image.Point{startx, starty}, draw.Src)
 	}
 
@@ -148,10 +322,10 @@ func emulateKeyPress(keys string) {
 
 	kk := strings.Split(keys, "-")
 	for i, k := range kk {
-		k = formatKeycodes(strings.TrimSpace(k))
-		kc, err := strconv.Atoi(k)
+		kc, err := parseKeycode(strings.TrimSpace(k))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s is not a valid keycode: %s\n", k, err)
+			fmt.Fprintln(os.Stderr, err)
+			return
 		}
 
 		if i+1 < len(kk) {
@@ -174,11 +348,25 @@ func emulateClipboard(text string) {
 	emulateKeyPress("29-47") // ctrl-v
 }
 
-// executes a dbus method.
-func executeDBusMethod(object, path, method, args string) {
-	call := dbusConn.Object(object, dbus.ObjectPath(path)).Call(method, 0, args)
-	if call.Err != nil {
-		fmt.Fprintf(os.Stderr, "dbus call failed: %s\n", call.Err)
+// executes a dbus action. If it has a label, the reply gets shown on the key
+// that triggered it.
+func (d *Deck) executeDBus(dev Device, key int, c DBusConfig) {
+	reply, err := executeDBus(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbus call failed: %s\n", err)
+		return
+	}
+	if c.Label == "" || key < 0 || len(reply) == 0 {
+		return
+	}
+
+	opts := WidgetConfig{
+		Config: map[string]interface{}{
+			"label": formatValue(c.Label, reply...),
+		},
+	}
+	if err := d.overrideKey(dev, uint8(key), opts, dbusReplyTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't show dbus reply: %s\n", err)
 	}
 }
 
@@ -206,7 +394,7 @@ func executeCommand(cmd string) {
 }
 
 // triggerAction triggers an action.
-func (d *Deck) triggerAction(dev *streamdeck.Device, index uint8, hold bool) {
+func (d *Deck) triggerAction(dev Device, index uint8, hold bool) {
 	for _, w := range d.Widgets {
 		if w.Key() != index {
 			continue
@@ -219,50 +407,188 @@ func (d *Deck) triggerAction(dev *streamdeck.Device, index uint8, hold bool) {
 			a = w.Action()
 		}
 
+		if !hold {
+			if ow, ok := w.(*OverrideWidget); ok {
+				w = ow.Widget()
+			}
+			if tw, ok := w.(*ToggleWidget); ok {
+				tw.Toggle()
+			}
+		}
+
 		if a == nil {
 			w.TriggerAction(hold)
 			continue
 		}
 
-		if a.Deck != "" {
-			d, err := LoadDeck(dev, filepath.Dir(d.File), a.Deck)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Can't load deck:", err)
-				return
+		d.runAction(dev, int(index), a)
+	}
+}
+
+// triggerGesture triggers the action a key has configured for a gesture.
+func (d *Deck) triggerGesture(dev Device, index uint8, g gesture) {
+	switch g {
+	case gesturePress:
+		d.triggerAction(dev, index, false)
+	case gestureHold:
+		d.triggerAction(dev, index, true)
+	case gestureDouble:
+		d.runAction(dev, int(index), d.keys[index].ActionDouble)
+	case gestureRelease:
+		d.runAction(dev, int(index), d.keys[index].ActionRelease)
+	}
+}
+
+// returns how a key reacts to gestures.
+func (d *Deck) keyBehavior(index uint8) keyBehavior {
+	b := keyBehavior{
+		holdTime: longPressDuration,
+	}
+	if d.holdTime > 0 {
+		b.holdTime = d.holdTime
+	}
+
+	if k, ok := d.keys[index]; ok {
+		if k.HoldTime > 0 {
+			b.holdTime = time.Duration(k.HoldTime) * time.Millisecond
+		}
+		b.doubleTap = k.ActionDouble != nil
+
+		if k.Repeat != nil {
+			b.repeat = defaultRepeatInterval
+			if k.Repeat.Interval > 0 {
+				b.repeat = time.Duration(k.Repeat.Interval) * time.Millisecond
 			}
-			if err := dev.Clear(); err != nil {
-				fatal(err)
-				return
+			b.repeatDelay = b.holdTime
+			if k.Repeat.Delay > 0 {
+				b.repeatDelay = time.Duration(k.Repeat.Delay) * time.Millisecond
 			}
+		}
+	}
+
+	return b
+}
+
+// runs an action, followed by its steps. key is the index of the key that
+// triggered it, or -1.
+func (d *Deck) runAction(dev Device, key int, a *ActionConfig) {
+	if a == nil {
+		return
+	}
 
-			deck = d
-			deck.updateWidgets()
+	// waiting would block the event loop, so sequences run on their own
+	// goroutine
+	if a.Wait != "" || len(a.Steps) > 0 {
+		go executeSteps(dev, key, a)
+		return
+	}
+
+	d.executeAction(dev, key, a)
+	if a.Exec != "" {
+		go executeCommand(a.Exec)
+	}
+}
+
+// executes an action followed by its sequence of steps. Each step, including
+// its command, finishes before the next one starts.
+func executeSteps(dev Device, key int, a *ActionConfig) {
+	executeStep(dev, key, a)
+
+	for i := range a.Steps {
+		repeat := a.Steps[i].Repeat
+		if repeat < 1 {
+			repeat = 1
 		}
-		if a.Keycode != "" {
-			emulateKeyPresses(a.Keycode)
+
+		for j := 0; j < repeat; j++ {
+			executeStep(dev, key, &a.Steps[i])
+		}
+	}
+}
+
+// executes a step of a sequence. Its wait and command happen on the calling
+// goroutine, everything else gets handed to the event loop, which owns the
+// deck's state. As earlier steps may have switched decks, the step applies
+// to the current deck.
+func executeStep(dev Device, key int, a *ActionConfig) {
+	if a.Wait != "" {
+		t, err := time.ParseDuration(a.Wait)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid wait duration %s: %s\n", a.Wait, err)
+			return
 		}
-		if a.Paste != "" {
-			emulateClipboard(a.Paste)
+		time.Sleep(t)
+	}
+
+	done := make(chan struct{})
+	actionSteps <- func() {
+		deck.executeAction(dev, key, a)
+		close(done)
+	}
+	<-done
+
+	if a.Exec != "" {
+		executeCommand(a.Exec)
+	}
+}
+
+// executes an action, except for its command and wait.
+func (d *Deck) executeAction(dev Device, key int, a *ActionConfig) {
+	if a.Deck != "" {
+		if err := navigateDeck(dev, filepath.Dir(d.File), a.Deck); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't switch deck:", err)
+			return
 		}
-		if a.DBus.Method != "" {
-			executeDBusMethod(a.DBus.Object, a.DBus.Path, a.DBus.Method, a.DBus.Value)
+	}
+	if a.Keycode != "" {
+		emulateKeyPresses(a.Keycode)
+	}
+	if a.Paste != "" {
+		emulateClipboard(a.Paste)
+	}
+	if a.DBus.Method != "" || a.DBus.Property != "" {
+		d.executeDBus(dev, key, a.DBus)
+	}
+	if a.Volume != nil {
+		if err := adjustVolume(*a.Volume); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't change volume: %s\n", err)
 		}
-		if a.Exec != "" {
-			go executeCommand(a.Exec)
+	}
+	if a.Device != "" {
+		switch {
+		case a.Device == "sleep":
+			if err := dev.Sleep(); err != nil {
+				fatalf("error: %v\n", err)
+			}
+
+		case strings.HasPrefix(a.Device, "brightness"):
+			d.adjustBrightness(dev, strings.TrimPrefix(a.Device, "brightness"))
+
+		case a.Device == "pin":
+			setPinned(!pinned)
+
+		default:
+			fmt.Fprintln(os.Stderr, "Unrecognized special action:", a.Device)
 		}
-		if a.Device != "" {
-			switch {
-			case a.Device == "sleep":
-				if err := dev.Sleep(); err != nil {
-					fatalf("error: %v\n", err)
-				}
+	}
+}
 
-			case strings.HasPrefix(a.Device, "brightness"):
-				d.adjustBrightness(dev, strings.TrimPrefix(a.Device, "brightness"))
+// handleSignal passes a D-Bus signal on to the widgets and triggers the
+// actions of matching signal hooks.
+func (d *Deck) handleSignal(dev Device, e SignalEvent) {
+	for _, w := range d.Widgets {
+		if ow, ok := w.(*OverrideWidget); ok {
+			w = ow.Widget()
+		}
+		if sw, ok := w.(signalWidget); ok {
+			sw.Signal(e)
+		}
+	}
 
-			default:
-				fmt.Fprintln(os.Stderr, "Unrecognized special action:", a.Device)
-			}
+	for _, s := range d.signals {
+		if s.match.matches(e) {
+			verbosef("Triggering action for signal %s", e.Signal.Name)
+			d.runAction(dev, -1, s.action)
 		}
 	}
 }
@@ -270,6 +596,13 @@ func (d *Deck) triggerAction(dev *streamdeck.Device, index uint8, hold bool) {
 // updateWidgets updates/repaints all the widgets.
 func (d *Deck) updateWidgets() {
 	for _, w := range d.Widgets {
+		if ow, ok := w.(*OverrideWidget); ok && ow.Expired() {
+			if err := d.clearOverride(ow.dev, ow.Key()); err != nil {
+				fatalf("error: %v", err)
+			}
+			continue
+		}
+
 		if !w.RequiresUpdate() {
 			continue
 		}
@@ -282,12 +615,27 @@ func (d *Deck) updateWidgets() {
 }
 
 // adjustBrightness adjusts the brightness.
-func (d *Deck) adjustBrightness(dev *streamdeck.Device, value string) {
-	if len(value) == 0 {
-		fmt.Fprintln(os.Stderr, "No brightness value specified")
+func (d *Deck) adjustBrightness(dev Device, value string) {
+	v, err := parseBrightness(value, int64(*brightness))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
 
+	if err := dev.SetBrightness(uint8(v)); err != nil {
+		fatalf("error: %v\n", err)
+	}
+
+	*brightness = uint(v)
+}
+
+// parseBrightness parses a brightness adjustment like "+10", "-5" or "=50"
+// relative to the current brightness.
+func parseBrightness(value string, current int64) (int64, error) {
+	if len(value) == 0 {
+		return 0, errors.New("no brightness value specified")
+	}
+
 	v := int64(math.MinInt64)
 	if len(value) > 1 {
 		nv, err := strconv.ParseInt(value[1:], 10, 64)
@@ -302,19 +650,18 @@ func (d *Deck) adjustBrightness(dev *streamdeck.Device, value string) {
 		if v == math.MinInt64 {
 			v = 10
 		}
-		v = int64(*brightness) - v
+		v = current - v
 	case '+': // brightness+[n]:
 		if v == math.MinInt64 {
 			v = 10
 		}
-		v = int64(*brightness) + v
+		v = current + v
 	default:
 		v = math.MinInt64
 	}
 
 	if v == math.MinInt64 {
-		fmt.Fprintf(os.Stderr, "Could not grok the brightness from value '%s'\n", value)
-		return
+		return 0, fmt.Errorf("could not grok the brightness from value '%s'", value)
 	}
 
 	if v < 1 {
@@ -322,9 +669,6 @@ func (d *Deck) adjustBrightness(dev *streamdeck.Device, value string) {
 	} else if v > 100 {
 		v = 100
 	}
-	if err := dev.SetBrightness(uint8(v)); err != nil {
-		fatalf("error: %v\n", err)
-	}
 
-	*brightness = uint(v)
+	return v, nil
 }
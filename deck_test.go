@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// returns a gradient spanning the entire panel of dev.
+func testBackground(dev Device) image.Image {
+	cols := int(dev.Columns())
+	rows := int(dev.Rows())
+	pixels := int(dev.Pixels())
+	padding := int(dev.Padding())
+
+	width := cols*pixels + (cols-1)*padding
+	height := rows*pixels + (rows-1)*padding
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+
+	return img
+}
+
+func TestBackgroundForKey(t *testing.T) {
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		d := Deck{
+			Background: testBackground(dev),
+		}
+		pixels := int(dev.Pixels())
+		padding := int(dev.Padding())
+
+		for i := uint8(0); i < dev.Keys(); i++ {
+			bg := d.backgroundForKey(dev, i)
+			if bg.Bounds() != image.Rect(0, 0, pixels, pixels) {
+				t.Fatalf("key %d: unexpected bounds %v", i, bg.Bounds())
+			}
+
+			// the key's corners must match the matching spot on the panel
+			x := int(i%dev.Columns()) * (pixels + padding)
+			y := int(i/dev.Columns()) * (pixels + padding)
+			for _, p := range []image.Point{{0, 0}, {pixels - 1, pixels - 1}} {
+				want := d.Background.At(x+p.X, y+p.Y)
+				if got := bg.At(p.X, p.Y); got != want {
+					t.Errorf("key %d at %v: expected %v, got %v", i, p, want, got)
+				}
+			}
+
+			d.Widgets = append(d.Widgets, NewBaseWidget(dev, "", i, nil, nil, bg))
+		}
+
+		d.updateWidgets()
+		assertGolden(t, fmt.Sprintf("deck_background_%d", pixels), dev.Image())
+	})
+}
+
+func TestBackgroundForKeyWithoutBackground(t *testing.T) {
+	dev := NewVirtualDevice(virtualModels["original"], "")
+
+	bg := (Deck{}).backgroundForKey(dev, 3)
+	if got := bg.At(10, 10); got != (color.RGBA{}) {
+		t.Errorf("expected a transparent background, got %v", got)
+	}
+}
+
+func TestLoadDeckFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.deck": `parent = "parent.deck"
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      icon = "icon.png"
+`,
+		"parent.deck": `[[keys]]
+  index = 1
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      label = "Parent"
+`,
+	}
+	icon, err := ioutil.ReadFile("decks/assets/go-next.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["icon.png"] = string(icon)
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	d, err := LoadDeck(dev, ".", filepath.Join(dir, "main.deck"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "main.deck"),
+		filepath.Join(dir, "parent.deck"),
+		filepath.Join(dir, "icon.png"),
+	}
+	if len(d.Files) != len(want) {
+		t.Fatalf("expected files %v, got %v", want, d.Files)
+	}
+	for i := range want {
+		if d.Files[i] != want[i] {
+			t.Errorf("expected file %s, got %s", want[i], d.Files[i])
+		}
+	}
+}
+
+func TestActionSequence(t *testing.T) {
+	orig := *brightness
+	defer func() { *brightness = orig }()
+	*brightness = 50
+
+	defer func(d *Deck) { deck = d }(deck)
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	deck = &Deck{}
+	deck.runAction(dev, -1, &ActionConfig{
+		Wait:   "10ms",
+		Device: "brightness=60",
+		Steps: []ActionConfig{
+			{Device: "brightness-10", Repeat: 3},
+			{Wait: "1ms", Device: "brightness+5"},
+		},
+	})
+
+	// the steps get handed to the event loop, one at a time
+	if *brightness != 50 {
+		t.Fatal("expected sequence not to run on the event loop")
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case step := <-actionSteps:
+			step()
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for step %d", i)
+		}
+	}
+
+	select {
+	case <-actionSteps:
+		t.Error("unexpected step")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if dev.Brightness() != 35 {
+		t.Errorf("expected brightness 35, got %d", dev.Brightness())
+	}
+}
+
+// runs n steps handed to the event loop, and returns when each of them started.
+func runSteps(t *testing.T, n int) []time.Time {
+	t.Helper()
+
+	var times []time.Time
+	for i := 0; i < n; i++ {
+		select {
+		case step := <-actionSteps:
+			times = append(times, time.Now())
+			step()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for step %d", i)
+		}
+	}
+
+	return times
+}
+
+func TestActionSequenceCommands(t *testing.T) {
+	defer func(d *Deck) { deck = d }(deck)
+
+	// the command of the first action finishes before the next step starts
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	deck = &Deck{}
+	deck.runAction(dev, -1, &ActionConfig{
+		Exec:  "sleep 0.2",
+		Steps: []ActionConfig{{Device: "pin"}, {Device: "pin"}},
+	})
+
+	times := runSteps(t, 3)
+	if d := times[1].Sub(times[0]); d < 200*time.Millisecond {
+		t.Errorf("expected step to wait for the command, started after %s", d)
+	}
+}
+
+func TestActionSequenceSwitchesDeck(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.deck", "sub/media.deck", "sub/player.deck"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(d *Deck, start string, history []string) {
+		deck, startDeck, deckHistory = d, start, history
+	}(deck, startDeck, deckHistory)
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	var err error
+	deck, err = LoadDeck(dev, dir, "main.deck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startDeck = deck.File
+	deckHistory = nil
+
+	// later steps apply to the deck switched to, not the one they started on
+	deck.runAction(dev, -1, &ActionConfig{
+		Steps: []ActionConfig{{Deck: "sub/media.deck"}, {Deck: "player.deck"}},
+	})
+	runSteps(t, 3)
+
+	if want := filepath.Join(dir, "sub", "player.deck"); deck.File != want {
+		t.Errorf("expected deck %s, got %s", want, deck.File)
+	}
+}
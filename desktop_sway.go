@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// i3 IPC message types, see https://i3wm.org/docs/ipc.html
+const (
+	i3RunCommand uint32 = 0
+	i3Subscribe  uint32 = 2
+	i3GetTree    uint32 = 4
+
+	i3WindowEvent uint32 = 0x80000003
+)
+
+// i3Magic starts every message of the i3 IPC protocol.
+const i3Magic = "i3-ipc"
+
+// Sway provides an interface to a sway or i3 session, using the i3 IPC
+// protocol.
+type Sway struct {
+	path string
+
+	mu           sync.Mutex // guards conn and activeWindow
+	conn         net.Conn
+	activeWindow Window
+}
+
+// i3Node is a node of the layout tree.
+type i3Node struct {
+	ID               uint64 `json:"id"`
+	Type             string `json:"type"`
+	Name             string `json:"name"`
+	Focused          bool   `json:"focused"`
+	AppID            string `json:"app_id"`
+	WindowProperties struct {
+		Class string `json:"class"`
+	} `json:"window_properties"`
+	Nodes         []i3Node `json:"nodes"`
+	FloatingNodes []i3Node `json:"floating_nodes"`
+}
+
+// i3WindowChange is the payload of a window event.
+type i3WindowChange struct {
+	Change    string `json:"change"`
+	Container i3Node `json:"container"`
+}
+
+// ConnectSway establishes a connection with the IPC socket of sway or i3.
+func ConnectSway(path string) (*Sway, error) {
+	if path == "" {
+		return nil, errors.New("no IPC socket")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sway{
+		path: path,
+		conn: conn,
+	}, nil
+}
+
+// Close terminates the connection.
+func (s *Sway) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_ = s.conn.Close()
+}
+
+// TrackWindows monitors the active window.
+func (s *Sway) TrackWindows(ch chan interface{}, _ time.Duration) {
+	var events []interface{}
+	if tree, err := s.tree(); err == nil {
+		if n, ok := tree.focused(); ok {
+			s.setActiveWindow(n.window())
+			events = append(events, ActiveWindowChangedEvent{
+				Window: n.window(),
+			})
+		}
+	}
+
+	// events get delivered on a connection of their own
+	conn, err := net.Dial("unix", s.path)
+	if err == nil {
+		if _, err = i3Request(conn, i3Subscribe, []byte(`["window"]`)); err != nil {
+			_ = conn.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not subscribe to window events: %s\n", err)
+		conn = nil
+	}
+
+	go func() {
+		for _, e := range events {
+			ch <- e
+		}
+		if conn == nil {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+
+		for {
+			t, payload, err := i3Read(conn)
+			if err != nil {
+				verbosef("IPC connection closed: %s", err)
+				return
+			}
+			if t != i3WindowEvent {
+				continue
+			}
+
+			var e i3WindowChange
+			if err := json.Unmarshal(payload, &e); err != nil {
+				verbosef("Invalid window event: %s", err)
+				continue
+			}
+
+			win := e.Container.window()
+			switch e.Change {
+			case "focus":
+				if win.ID != s.ActiveWindow().ID {
+					s.setActiveWindow(win)
+					ch <- ActiveWindowChangedEvent{
+						Window: win,
+					}
+				}
+
+			case "close":
+				ch <- WindowClosedEvent{
+					Window: win,
+				}
+			}
+		}
+	}()
+}
+
+// ActiveWindow returns the currently active window.
+func (s *Sway) ActiveWindow() Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.activeWindow
+}
+
+// Windows returns all windows managed by the window manager.
+func (s *Sway) Windows() ([]Window, error) {
+	tree, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var windows []Window
+	tree.walk(func(n i3Node) {
+		if n.isWindow() {
+			windows = append(windows, n.window())
+		}
+	})
+
+	return windows, nil
+}
+
+// RequestActivation requests a window to be focused.
+func (s *Sway) RequestActivation(w Window) error {
+	return s.command(fmt.Sprintf("[con_id=%d] focus", w.ID))
+}
+
+// CloseWindow closes a window.
+func (s *Sway) CloseWindow(w Window) error {
+	return s.command(fmt.Sprintf("[con_id=%d] kill", w.ID))
+}
+
+func (s *Sway) setActiveWindow(w Window) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.activeWindow = w
+}
+
+// sends a request and waits for its reply.
+func (s *Sway) request(t uint32, payload []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return i3Request(s.conn, t, payload)
+}
+
+// runs a command.
+func (s *Sway) command(cmd string) error {
+	reply, err := s.request(i3RunCommand, []byte(cmd))
+	if err != nil {
+		return err
+	}
+
+	var results []struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(reply, &results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if !r.Success {
+			return fmt.Errorf("command failed: %s", r.Error)
+		}
+	}
+
+	return nil
+}
+
+// returns the layout tree.
+func (s *Sway) tree() (i3Node, error) {
+	var tree i3Node
+
+	reply, err := s.request(i3GetTree, nil)
+	if err != nil {
+		return tree, err
+	}
+
+	err = json.Unmarshal(reply, &tree)
+	return tree, err
+}
+
+// walks a node and all of its children.
+func (n i3Node) walk(fn func(n i3Node)) {
+	fn(n)
+	for _, c := range n.Nodes {
+		c.walk(fn)
+	}
+	for _, c := range n.FloatingNodes {
+		c.walk(fn)
+	}
+}
+
+// returns the focused window.
+func (n i3Node) focused() (i3Node, bool) {
+	var focused i3Node
+	var found bool
+	n.walk(func(n i3Node) {
+		if n.Focused && n.isWindow() {
+			focused, found = n, true
+		}
+	})
+
+	return focused, found
+}
+
+// returns true if the node is a window, rather than a container.
+func (n i3Node) isWindow() bool {
+	return (n.Type == "con" || n.Type == "floating_con") &&
+		len(n.Nodes) == 0 && len(n.FloatingNodes) == 0 &&
+		(n.AppID != "" || n.WindowProperties.Class != "")
+}
+
+func (n i3Node) window() Window {
+	class := n.AppID
+	if class == "" {
+		// X11 windows, either running in i3 or XWayland
+		class = n.WindowProperties.Class
+	}
+
+	return Window{
+		ID:    n.ID,
+		Class: class,
+		Name:  n.Name,
+	}
+}
+
+// writes a message. The protocol uses the native byte order, which is little
+// endian on all platforms sway and i3 run on.
+func i3Write(w io.Writer, t uint32, payload []byte) error {
+	msg := make([]byte, len(i3Magic)+8, len(i3Magic)+8+len(payload))
+	copy(msg, i3Magic)
+	binary.LittleEndian.PutUint32(msg[len(i3Magic):], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(msg[len(i3Magic)+4:], t)
+
+	_, err := w.Write(append(msg, payload...))
+	return err
+}
+
+// reads a message.
+func i3Read(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, len(i3Magic)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(i3Magic)]) != i3Magic {
+		return 0, nil, errors.New("invalid IPC message")
+	}
+
+	length := binary.LittleEndian.Uint32(header[len(i3Magic):])
+	t := binary.LittleEndian.Uint32(header[len(i3Magic)+4:])
+	payload := make([]byte, length)
+	_, err := io.ReadFull(r, payload)
+	return t, payload, err
+}
+
+// sends a request and returns the payload of its reply, skipping events.
+func i3Request(rw io.ReadWriter, t uint32, payload []byte) ([]byte, error) {
+	if err := i3Write(rw, t, payload); err != nil {
+		return nil, err
+	}
+
+	for {
+		rt, reply, err := i3Read(rw)
+		if err != nil {
+			return nil, err
+		}
+		if rt == t {
+			return reply, nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testTree = `{
+  "id": 1, "type": "root", "nodes": [
+    {"id": 2, "type": "output", "nodes": [
+      {"id": 3, "type": "workspace", "nodes": [
+        {"id": 4, "type": "con", "name": "main.go - Code", "app_id": "code"},
+        {"id": 5, "type": "con", "name": "GIMP", "focused": true,
+          "window_properties": {"class": "Gimp-2.10"}}
+      ], "floating_nodes": [
+        {"id": 6, "type": "floating_con", "name": "Calculator", "app_id": "calc"}
+      ]}
+    ]}
+  ]
+}`
+
+// fakeSway serves the i3 IPC protocol, recording all commands it receives.
+func fakeSway(t *testing.T, commands chan<- string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sway.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close() //nolint:errcheck
+
+				for {
+					mt, payload, err := i3Read(conn)
+					if err != nil {
+						return
+					}
+
+					switch mt {
+					case i3GetTree:
+						_ = i3Write(conn, mt, []byte(testTree))
+
+					case i3RunCommand:
+						commands <- string(payload)
+						_ = i3Write(conn, mt, []byte(`[{"success": true}]`))
+
+					case i3Subscribe:
+						_ = i3Write(conn, mt, []byte(`{"success": true}`))
+						_ = i3Write(conn, i3WindowEvent, []byte(`{"change": "focus",
+							"container": {"id": 4, "type": "con", "name": "main.go - Code", "app_id": "code"}}`))
+						_ = i3Write(conn, i3WindowEvent, []byte(`{"change": "close",
+							"container": {"id": 6, "type": "floating_con", "app_id": "calc"}}`))
+					}
+				}
+			}()
+		}
+	}()
+
+	return path
+}
+
+func TestSway(t *testing.T) {
+	commands := make(chan string, 1)
+	s, err := ConnectSway(fakeSway(t, commands))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	windows, err := s.Windows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Window{
+		{ID: 4, Class: "code", Name: "main.go - Code"},
+		{ID: 5, Class: "Gimp-2.10", Name: "GIMP"},
+		{ID: 6, Class: "calc", Name: "Calculator"},
+	}
+	if !reflect.DeepEqual(windows, want) {
+		t.Errorf("expected windows %v, got %v", want, windows)
+	}
+
+	ch := make(chan interface{})
+	s.TrackWindows(ch, time.Second)
+
+	// the initially focused window, followed by the events
+	var events []interface{}
+	for len(events) < 3 {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 events, got %v", events)
+		}
+	}
+	wantEvents := []interface{}{
+		ActiveWindowChangedEvent{Window: want[1]},
+		ActiveWindowChangedEvent{Window: want[0]},
+		WindowClosedEvent{Window: Window{ID: 6, Class: "calc"}},
+	}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("expected events %v, got %v", wantEvents, events)
+	}
+	if s.ActiveWindow().ID != 4 {
+		t.Errorf("expected window 4 to be active, got %d", s.ActiveWindow().ID)
+	}
+
+	if err := s.RequestActivation(want[2]); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-commands; cmd != "[con_id=6] focus" {
+		t.Errorf("expected focus command, got %q", cmd)
+	}
+	if err := s.CloseWindow(want[2]); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-commands; cmd != "[con_id=6] kill" {
+		t.Errorf("expected kill command, got %q", cmd)
+	}
+}
@@ -29,24 +29,7 @@ type Xorg struct {
 	nameAtom     *xproto.InternAtomReply
 	classAtom    *xproto.InternAtomReply
 	activeWindow Window
-}
-
-// ActiveWindowChangedEvent gets emitted when the active window changes.
-type ActiveWindowChangedEvent struct {
-	Window Window
-}
-
-// WindowClosedEvent gets emitted when a window gets closed.
-type WindowClosedEvent struct {
-	Window Window
-}
-
-// Window describes an X11 window.
-type Window struct {
-	ID    uint32
-	Class string
-	Name  string
-	Icon  image.Image
+	screensaver  bool // the screensaver extension is available
 }
 
 // Connect establishes a connection with an Xorg display.
@@ -64,14 +47,15 @@ func Connect(display string) (*Xorg, error) {
 		return nil, err
 	}
 
+	setup := xproto.Setup(x.conn)
+	x.root = setup.DefaultScreen(x.conn).Root
+
 	if err := screensaver.Init(x.conn); err == nil {
 		drw := xproto.Drawable(x.root)
 		screensaver.SelectInput(x.conn, drw, screensaver.EventNotifyMask)
+		x.screensaver = true
 	}
 
-	setup := xproto.Setup(x.conn)
-	x.root = setup.DefaultScreen(x.conn).Root
-
 	x.activeAtom = x.atom("_NET_ACTIVE_WINDOW")
 	x.netNameAtom = x.atom("_NET_WM_NAME")
 	x.nameAtom = x.atom("WM_NAME")
@@ -87,7 +71,8 @@ func (x Xorg) Close() {
 	x.conn.Close()
 }
 
-// TrackWindows monitors the active window.
+// TrackWindows monitors the active window. Every timeout it also emits an
+// IdleEvent.
 func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 	if win, ok := x.window(); ok {
 		x.activeWindow = win
@@ -105,6 +90,9 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 	go x.waitForEvent(events)
 
 	go func() {
+		ticker := time.NewTicker(timeout)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case event := <-events:
@@ -112,7 +100,7 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 				case xproto.DestroyNotifyEvent:
 					ch <- WindowClosedEvent{
 						Window: Window{
-							ID: uint32(e.Window),
+							ID: uint64(e.Window),
 						},
 					}
 
@@ -128,18 +116,18 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 								}()
 							}
 						}
-						// Wakeup
 					}
 				case screensaver.NotifyEvent:
-					switch e.State {
-					case screensaver.StateOn:
-						// Snooze(x.queryIdle())
-					default:
-						// Wakeup
+					ch <- ScreenSaverEvent{
+						Active: e.State == screensaver.StateOn,
+					}
+				}
+			case <-ticker.C:
+				if x.screensaver {
+					ch <- IdleEvent{
+						Idle: x.queryIdle(),
 					}
 				}
-			case <-time.After(timeout):
-				// Snooze(x.queryIdle())
 			}
 		}
 	}()
@@ -150,6 +138,36 @@ func (x Xorg) ActiveWindow() Window {
 	return x.activeWindow
 }
 
+// Windows returns all windows managed by the window manager.
+func (x Xorg) Windows() ([]Window, error) {
+	ids, err := ewmh.ClientListGet(x.util)
+	if err != nil {
+		return nil, err
+	}
+
+	var windows []Window
+	for _, id := range ids {
+		class, err := x.class(id)
+		if err != nil {
+			continue
+		}
+		name, err := x.name(id)
+		if err != nil {
+			continue
+		}
+		icon, _ := xgraphics.FindIcon(x.util, id, 128, 128)
+
+		windows = append(windows, Window{
+			ID:    uint64(id),
+			Class: class,
+			Name:  name,
+			Icon:  icon,
+		})
+	}
+
+	return windows, nil
+}
+
 // RequestActivation requests a window to be focused.
 func (x Xorg) RequestActivation(w Window) error {
 	return ewmh.ActiveWindowReq(x.util, xproto.Window(w.ID))
@@ -241,7 +259,7 @@ func (x Xorg) window() (Window, bool) {
 	x.spy(id)
 
 	return Window{
-		ID:    uint32(id),
+		ID:    uint64(id),
 		Class: class,
 		Name:  name,
 		Icon:  icon,
@@ -264,7 +282,6 @@ func (x Xorg) waitForEvent(events chan<- xgb.Event) {
 	}
 }
 
-/*
 func (x Xorg) queryIdle() time.Duration {
 	info, err := screensaver.QueryInfo(x.conn, xproto.Drawable(x.root)).Reply()
 	if err != nil {
@@ -273,4 +290,3 @@ func (x Xorg) queryIdle() time.Duration {
 	}
 	return time.Duration(info.MsSinceUserInput) * time.Millisecond
 }
-*/
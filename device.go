@@ -0,0 +1,147 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/muesli/streamdeck"
+)
+
+// Device is an interface implemented by all device backends.
+type Device interface {
+	Serial() string
+	Columns() uint8
+	Rows() uint8
+	Keys() uint8
+	Pixels() uint
+	DPI() uint
+	Padding() uint
+
+	Open() error
+	Close() error
+	Reset() error
+	Clear() error
+	FirmwareVersion() (string, error)
+
+	ReadKeys() (chan streamdeck.Key, error)
+	SetImage(index uint8, img image.Image) error
+	SetBrightness(percent uint8) error
+
+	Sleep() error
+	Wake() error
+	Asleep() bool
+	SetSleepFadeDuration(t time.Duration)
+	SetSleepTimeout(t time.Duration)
+}
+
+// StreamDeck is a Device backed by a physical Stream Deck.
+type StreamDeck struct {
+	dev *streamdeck.Device
+}
+
+// NewStreamDeck returns a new StreamDeck for a physical device.
+func NewStreamDeck(dev *streamdeck.Device) *StreamDeck {
+	return &StreamDeck{
+		dev: dev,
+	}
+}
+
+// Serial returns the serial number of the device.
+func (d *StreamDeck) Serial() string {
+	return d.dev.Serial
+}
+
+// Columns returns the amount of key columns.
+func (d *StreamDeck) Columns() uint8 {
+	return d.dev.Columns
+}
+
+// Rows returns the amount of key rows.
+func (d *StreamDeck) Rows() uint8 {
+	return d.dev.Rows
+}
+
+// Keys returns the amount of keys.
+func (d *StreamDeck) Keys() uint8 {
+	return d.dev.Keys
+}
+
+// Pixels returns the width and height of a key image in pixels.
+func (d *StreamDeck) Pixels() uint {
+	return d.dev.Pixels
+}
+
+// DPI returns the pixel density of the key displays.
+func (d *StreamDeck) DPI() uint {
+	return d.dev.DPI
+}
+
+// Padding returns the gap between two keys in pixels.
+func (d *StreamDeck) Padding() uint {
+	return d.dev.Padding
+}
+
+// Open opens the device for input/output.
+func (d *StreamDeck) Open() error {
+	return d.dev.Open()
+}
+
+// Close closes the connection with the device.
+func (d *StreamDeck) Close() error {
+	return d.dev.Close()
+}
+
+// Reset clears all key images and shows the standby image.
+func (d *StreamDeck) Reset() error {
+	return d.dev.Reset()
+}
+
+// Clear sets a black image on all keys.
+func (d *StreamDeck) Clear() error {
+	return d.dev.Clear()
+}
+
+// FirmwareVersion returns the firmware version of the device.
+func (d *StreamDeck) FirmwareVersion() (string, error) {
+	return d.dev.FirmwareVersion()
+}
+
+// ReadKeys returns a channel emitting key presses and releases.
+func (d *StreamDeck) ReadKeys() (chan streamdeck.Key, error) {
+	return d.dev.ReadKeys()
+}
+
+// SetImage sets the image of a key.
+func (d *StreamDeck) SetImage(index uint8, img image.Image) error {
+	return d.dev.SetImage(index, img)
+}
+
+// SetBrightness sets the brightness from 0 to 100 percent.
+func (d *StreamDeck) SetBrightness(percent uint8) error {
+	return d.dev.SetBrightness(percent)
+}
+
+// Sleep puts the device asleep until the next key event.
+func (d *StreamDeck) Sleep() error {
+	return d.dev.Sleep()
+}
+
+// Wake wakes the device from sleep.
+func (d *StreamDeck) Wake() error {
+	return d.dev.Wake()
+}
+
+// Asleep returns true if the device is asleep.
+func (d *StreamDeck) Asleep() bool {
+	return d.dev.Asleep()
+}
+
+// SetSleepFadeDuration sets the duration of the sleep/wake fading animation.
+func (d *StreamDeck) SetSleepFadeDuration(t time.Duration) {
+	d.dev.SetSleepFadeDuration(t)
+}
+
+// SetSleepTimeout sets the time after which an idle device goes to sleep.
+func (d *StreamDeck) SetSleepTimeout(t time.Duration) {
+	d.dev.SetSleepTimeout(t)
+}
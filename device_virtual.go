@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muesli/streamdeck"
+)
+
+// VirtualModel describes the geometry of a virtual device.
+type VirtualModel struct {
+	Columns uint8
+	Rows    uint8
+	Pixels  uint
+	DPI     uint
+	Padding uint
+}
+
+// virtualModels mirrors the geometry of the physical devices.
+var virtualModels = map[string]VirtualModel{
+	"mini":     {Columns: 3, Rows: 2, Pixels: 80, DPI: 138, Padding: 16},
+	"original": {Columns: 5, Rows: 3, Pixels: 72, DPI: 124, Padding: 16},
+	"xl":       {Columns: 8, Rows: 4, Pixels: 96, DPI: 166, Padding: 16},
+}
+
+// VirtualDevice is an in-memory Device, compositing all key images into a
+// single panel image.
+type VirtualDevice struct {
+	model  VirtualModel
+	output string
+
+	mu         sync.Mutex
+	panel      *image.RGBA
+	dirty      bool
+	brightness uint8
+	asleep     bool
+	pressed    map[uint8]bool
+
+	keys   chan streamdeck.Key
+	cancel context.CancelFunc
+}
+
+// ParseVirtualModel returns the model for a name like "xl" or a custom
+// geometry in the format [columns]x[rows]@[pixels].
+func ParseVirtualModel(s string) (VirtualModel, error) {
+	if m, ok := virtualModels[s]; ok {
+		return m, nil
+	}
+
+	m := virtualModels["original"]
+	geometry := s
+	if i := strings.Index(s, "@"); i >= 0 {
+		pixels, err := strconv.ParseUint(s[i+1:], 10, 32)
+		if err != nil || pixels == 0 {
+			return m, fmt.Errorf("invalid key size in virtual device %s", s)
+		}
+		// scale the DPI, so fonts keep their relative size
+		m.DPI = m.DPI * uint(pixels) / m.Pixels
+		m.Pixels = uint(pixels)
+		geometry = s[:i]
+	}
+
+	p, err := formatCoord(geometry)
+	if err != nil || p.X <= 0 || p.Y <= 0 || p.X*p.Y > 255 {
+		return m, fmt.Errorf("invalid virtual device %s", s)
+	}
+	m.Columns = uint8(p.X)
+	m.Rows = uint8(p.Y)
+
+	return m, nil
+}
+
+// NewVirtualDevice returns a new VirtualDevice. If output is not empty, the
+// panel image gets written to it as PNG whenever it changes.
+func NewVirtualDevice(model VirtualModel, output string) *VirtualDevice {
+	d := &VirtualDevice{
+		model:   model,
+		output:  output,
+		pressed: make(map[uint8]bool),
+		keys:    make(chan streamdeck.Key),
+	}
+
+	cols := int(model.Columns)
+	rows := int(model.Rows)
+	pixels := int(model.Pixels)
+	padding := int(model.Padding)
+	d.panel = image.NewRGBA(image.Rect(0, 0,
+		cols*pixels+(cols-1)*padding,
+		rows*pixels+(rows-1)*padding))
+	_ = d.Clear()
+
+	return d
+}
+
+// Serial returns the serial number of the device.
+func (d *VirtualDevice) Serial() string {
+	return "virtual"
+}
+
+// Columns returns the amount of key columns.
+func (d *VirtualDevice) Columns() uint8 {
+	return d.model.Columns
+}
+
+// Rows returns the amount of key rows.
+func (d *VirtualDevice) Rows() uint8 {
+	return d.model.Rows
+}
+
+// Keys returns the amount of keys.
+func (d *VirtualDevice) Keys() uint8 {
+	return d.model.Columns * d.model.Rows
+}
+
+// Pixels returns the width and height of a key image in pixels.
+func (d *VirtualDevice) Pixels() uint {
+	return d.model.Pixels
+}
+
+// DPI returns the pixel density of the key displays.
+func (d *VirtualDevice) DPI() uint {
+	return d.model.DPI
+}
+
+// Padding returns the gap between two keys in pixels.
+func (d *VirtualDevice) Padding() uint {
+	return d.model.Padding
+}
+
+// Open starts writing the panel image to the output file.
+func (d *VirtualDevice) Open() error {
+	if d.output == "" || d.cancel != nil {
+		return nil
+	}
+
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		for {
+			select {
+			case <-time.After(100 * time.Millisecond):
+				if err := d.flush(); err != nil {
+					fmt.Fprintf(os.Stderr, "Can't write virtual device image: %s\n", err)
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops writing the panel image and writes it one last time.
+func (d *VirtualDevice) Close() error {
+	if d.cancel == nil {
+		return nil
+	}
+
+	d.cancel()
+	d.cancel = nil
+	return d.flush()
+}
+
+// Reset is a no-op, so the last panel image survives a shutdown.
+func (d *VirtualDevice) Reset() error {
+	return nil
+}
+
+// Clear sets a black image on all keys.
+func (d *VirtualDevice) Clear() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	draw.Draw(d.panel, d.panel.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	d.dirty = true
+	return nil
+}
+
+// FirmwareVersion returns the firmware version of the device.
+func (d *VirtualDevice) FirmwareVersion() (string, error) {
+	return "virtual", nil
+}
+
+// ReadKeys returns a channel emitting key presses and releases.
+func (d *VirtualDevice) ReadKeys() (chan streamdeck.Key, error) {
+	return d.keys, nil
+}
+
+// SetImage sets the image of a key.
+func (d *VirtualDevice) SetImage(index uint8, img image.Image) error {
+	if index >= d.Keys() {
+		return fmt.Errorf("key index %d out of range", index)
+	}
+	if img.Bounds().Dx() != int(d.model.Pixels) || img.Bounds().Dy() != int(d.model.Pixels) {
+		return fmt.Errorf("supplied image has wrong dimensions, expected %[1]dx%[1]d pixels", d.model.Pixels)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// like the physical displays, render transparent pixels as black
+	r := d.keyRect(index)
+	draw.Draw(d.panel, r, image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(d.panel, r, img, img.Bounds().Min, draw.Over)
+	d.dirty = true
+	return nil
+}
+
+// SetBrightness sets the brightness from 0 to 100 percent.
+func (d *VirtualDevice) SetBrightness(percent uint8) error {
+	if percent > 100 {
+		percent = 100
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.brightness = percent
+	return nil
+}
+
+// Brightness returns the current brightness.
+func (d *VirtualDevice) Brightness() uint8 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.brightness
+}
+
+// Sleep puts the device asleep until the next key press.
+func (d *VirtualDevice) Sleep() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.asleep = true
+	return nil
+}
+
+// Wake wakes the device from sleep.
+func (d *VirtualDevice) Wake() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.asleep = false
+	return nil
+}
+
+// Asleep returns true if the device is asleep.
+func (d *VirtualDevice) Asleep() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.asleep
+}
+
+// SetSleepFadeDuration is a no-op, the virtual device doesn't fade.
+func (d *VirtualDevice) SetSleepFadeDuration(_ time.Duration) {
+}
+
+// SetSleepTimeout is a no-op, the virtual device never falls asleep on its own.
+func (d *VirtualDevice) SetSleepTimeout(_ time.Duration) {
+}
+
+// Image returns a copy of the panel image.
+func (d *VirtualDevice) Image() *image.RGBA {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	img := image.NewRGBA(d.panel.Bounds())
+	draw.Draw(img, img.Bounds(), d.panel, image.Point{}, draw.Src)
+	return img
+}
+
+// KeyImage returns a copy of a single key's image.
+func (d *VirtualDevice) KeyImage(index uint8) *image.RGBA {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := d.keyRect(index)
+	img := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(img, img.Bounds(), d.panel, r.Min, draw.Src)
+	return img
+}
+
+// WritePNG writes the panel image as PNG.
+func (d *VirtualDevice) WritePNG(w io.Writer) error {
+	return png.Encode(w, d.Image())
+}
+
+// Press emits a key press. Like a physical device, a press on a sleeping
+// device only wakes it up.
+func (d *VirtualDevice) Press(index uint8) error {
+	return d.setKey(index, true)
+}
+
+// Release emits a key release.
+func (d *VirtualDevice) Release(index uint8) error {
+	return d.setKey(index, false)
+}
+
+// RunScript emits key events read from r, one command per line:
+//
+//	press [key]
+//	release [key]
+//	tap [key]
+//	hold [key] [duration]
+//	wait [duration]
+//
+// Empty lines and lines starting with # are ignored.
+func (d *VirtualDevice) RunScript(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if err := d.runScriptCommand(fields); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (d *VirtualDevice) runScriptCommand(fields []string) error {
+	cmd, args := fields[0], fields[1:]
+
+	var wantArgs int
+	switch cmd {
+	case "press", "release", "tap", "wait":
+		wantArgs = 1
+	case "hold":
+		wantArgs = 2
+	default:
+		return fmt.Errorf("unknown command %s", cmd)
+	}
+	if len(args) != wantArgs {
+		return fmt.Errorf("%s expects %d argument(s)", cmd, wantArgs)
+	}
+
+	if cmd == "wait" {
+		t, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		time.Sleep(t)
+		return nil
+	}
+
+	key, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid key %s", args[0])
+	}
+	index := uint8(key)
+
+	switch cmd {
+	case "press":
+		return d.Press(index)
+
+	case "release":
+		return d.Release(index)
+
+	case "tap":
+		if err := d.Press(index); err != nil {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+		return d.Release(index)
+
+	case "hold":
+		t, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		if err := d.Press(index); err != nil {
+			return err
+		}
+		time.Sleep(t)
+		return d.Release(index)
+	}
+
+	return nil
+}
+
+func (d *VirtualDevice) setKey(index uint8, pressed bool) error {
+	if index >= d.Keys() {
+		return fmt.Errorf("key index %d out of range", index)
+	}
+
+	d.mu.Lock()
+	if d.pressed[index] == pressed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.pressed[index] = pressed
+
+	// don't trigger a key event if the device is asleep, but wake it. The
+	// release of the waking press gets swallowed as well.
+	if d.asleep {
+		if pressed {
+			d.asleep = false
+			d.pressed[index] = false
+		}
+		d.mu.Unlock()
+		return nil
+	}
+	d.mu.Unlock()
+
+	d.keys <- streamdeck.Key{
+		Index:   index,
+		Pressed: pressed,
+	}
+	return nil
+}
+
+// returns the area of a key within the panel image.
+func (d *VirtualDevice) keyRect(index uint8) image.Rectangle {
+	pixels := int(d.model.Pixels)
+	padding := int(d.model.Padding)
+
+	x := int(index%d.model.Columns) * (pixels + padding)
+	y := int(index/d.model.Columns) * (pixels + padding)
+	return image.Rect(x, y, x+pixels, y+pixels)
+}
+
+// writes the panel image to the output file, if it changed.
+func (d *VirtualDevice) flush() error {
+	d.mu.Lock()
+	dirty := d.dirty
+	d.dirty = false
+	d.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	// write to a temporary file first, so readers never see a partial image
+	tmp := d.output + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := d.WritePNG(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.output)
+}
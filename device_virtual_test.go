@@ -0,0 +1,172 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muesli/streamdeck"
+)
+
+func TestParseVirtualModel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want VirtualModel
+	}{
+		{"xl", virtualModels["xl"]},
+		{"mini", virtualModels["mini"]},
+		{"4x2", VirtualModel{Columns: 4, Rows: 2, Pixels: 72, DPI: 124, Padding: 16}},
+		{"4x2@144", VirtualModel{Columns: 4, Rows: 2, Pixels: 144, DPI: 248, Padding: 16}},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseVirtualModel(tt.s)
+		if err != nil {
+			t.Errorf("%s: %s", tt.s, err)
+			continue
+		}
+		if m != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.s, tt.want, m)
+		}
+	}
+
+	for _, s := range []string{"", "huge", "0x3", "5x", "20x20", "5x3@", "5x3@0", "5x3@big"} {
+		if _, err := ParseVirtualModel(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+// returns the key events emitted while running script.
+func runTestScript(t *testing.T, d *VirtualDevice, script string) ([]streamdeck.Key, error) {
+	t.Helper()
+
+	keys, err := d.ReadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []streamdeck.Key
+	done := make(chan struct{})
+	go func() {
+		for k := range keys {
+			events = append(events, k)
+		}
+		close(done)
+	}()
+
+	err = d.RunScript(strings.NewReader(script))
+	close(keys)
+	<-done
+
+	return events, err
+}
+
+func TestVirtualDeviceScript(t *testing.T) {
+	d := NewVirtualDevice(virtualModels["mini"], "")
+
+	events, err := runTestScript(t, d, `
+# a comment
+press 1
+release 1
+release 1
+
+tap 2
+hold 0 10ms
+wait 1ms
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []streamdeck.Key{
+		{Index: 1, Pressed: true},
+		{Index: 1, Pressed: false},
+		{Index: 2, Pressed: true},
+		{Index: 2, Pressed: false},
+		{Index: 0, Pressed: true},
+		{Index: 0, Pressed: false},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+}
+
+func TestVirtualDeviceScriptWakes(t *testing.T) {
+	d := NewVirtualDevice(virtualModels["mini"], "")
+	_ = d.Sleep()
+
+	// the first press only wakes the device
+	events, err := runTestScript(t, d, "tap 3\ntap 3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Asleep() {
+		t.Error("expected device to be awake")
+	}
+
+	want := []streamdeck.Key{
+		{Index: 3, Pressed: true},
+		{Index: 3, Pressed: false},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+}
+
+func TestVirtualDeviceScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{"jump 1", "line 1: unknown command jump"},
+		{"\npress", "line 2: press expects 1 argument(s)"},
+		{"hold 1", "line 1: hold expects 2 argument(s)"},
+		{"tap one", "line 1: invalid key one"},
+		{"press 300", "line 1: invalid key 300"},
+		{"press 6", "line 1: key index 6 out of range"},
+		{"wait soon", `line 1: time: invalid duration "soon"`},
+		{"hold 1 long", `line 1: time: invalid duration "long"`},
+	}
+
+	for _, tt := range tests {
+		d := NewVirtualDevice(virtualModels["mini"], "")
+		_, err := runTestScript(t, d, tt.script)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: expected error %q, got %v", tt.script, tt.err, err)
+		}
+	}
+}
+
+func TestVirtualDeviceImage(t *testing.T) {
+	d := NewVirtualDevice(virtualModels["mini"], "")
+	red := color.RGBA{255, 0, 0, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	if err := d.SetImage(4, img); err != nil {
+		t.Fatal(err)
+	}
+
+	// key 4 is the second one in the bottom row
+	panel := d.Image()
+	if c := panel.RGBAAt(80+16, 80+16); c != red {
+		t.Errorf("expected key 4 to be red, got %v", c)
+	}
+	if c := panel.RGBAAt(80+8, 80+16); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("expected padding to be black, got %v", c)
+	}
+	if c := d.KeyImage(4).RGBAAt(40, 40); c != red {
+		t.Errorf("expected key image to be red, got %v", c)
+	}
+
+	if err := d.SetImage(6, img); err == nil {
+		t.Error("expected error for key out of range")
+	}
+	if err := d.SetImage(0, image.NewRGBA(image.Rect(0, 0, 72, 72))); err == nil {
+		t.Error("expected error for wrong image size")
+	}
+}
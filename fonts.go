@@ -1,11 +1,9 @@
 package main
 
 import (
-	"fmt"
 	"image"
 	"image/color"
 	"io/ioutil"
-	"os"
 
 	"github.com/flopp/go-findfont"
 	"github.com/golang/freetype"
@@ -84,23 +82,19 @@ func loadFont(name string) (*truetype.Font, error) {
 	return freetype.ParseFont(ttf)
 }
 
-func init() {
+// loads the fonts used for text rendering.
+func loadFonts() error {
 	var err error
 	ttfFont, err = loadFont("Roboto-Regular.ttf")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error loading font:", err)
-		os.Exit(1)
+		return err
 	}
 
 	ttfThinFont, err = loadFont("Roboto-Thin.ttf")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error loading font:", err)
-		os.Exit(1)
+		return err
 	}
 
 	ttfBoldFont, err = loadFont("Roboto-Bold.ttf")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error loading font:", err)
-		os.Exit(1)
-	}
+	return err
 }
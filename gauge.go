@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// gaugeStyles lists the ways a value can be drawn as a gauge.
+var gaugeStyles = []string{"hbar", "vbar", "arc", "pie"}
+
+// the share of an arc gauge's radius its ring takes up.
+const arcThickness = 0.3
+
+// checkGaugeStyle returns an error if style isn't a known gauge style.
+func checkGaugeStyle(style string) error {
+	for _, s := range gaugeStyles {
+		if s == style {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown gauge style %s, expected hbar, vbar, arc or pie", style)
+}
+
+// drawGauge draws value, a percentage, into r. clr is used for the frame of
+// bars and the empty part of arcs and pies, fillColor for the filled part.
+func drawGauge(img *image.RGBA, r image.Rectangle, style string, value float64, clr, fillColor color.Color) {
+	value = math.Max(0, math.Min(100, value))
+
+	switch style {
+	case "hbar":
+		drawHBar(img, r, value, clr, fillColor)
+	case "vbar":
+		drawVBar(img, r, value, clr, fillColor)
+	case "arc":
+		drawArc(img, r, value, dim(clr), fillColor)
+	case "pie":
+		drawPie(img, r, value, dim(clr), fillColor)
+	}
+}
+
+// returns the width of a gauge's frame, and of the gap between its frame and
+// its filling.
+func gaugeBorder(r image.Rectangle) int {
+	side := r.Dx()
+	if r.Dy() < side {
+		side = r.Dy()
+	}
+
+	return side/48 + 1
+}
+
+// drawHBar draws value as a bar filling up from the left.
+func drawHBar(img *image.RGBA, r image.Rectangle, value float64, clr, fillColor color.Color) {
+	inner := drawFrame(img, r, clr)
+	width := int(math.Round(float64(inner.Dx()) * value / 100))
+
+	draw.Draw(img,
+		image.Rect(inner.Min.X, inner.Min.Y, inner.Min.X+width, inner.Max.Y),
+		image.NewUniform(fillColor),
+		image.Point{}, draw.Over)
+}
+
+// drawVBar draws value as a bar filling up from the bottom.
+func drawVBar(img *image.RGBA, r image.Rectangle, value float64, clr, fillColor color.Color) {
+	inner := drawFrame(img, r, clr)
+	height := int(math.Round(float64(inner.Dy()) * value / 100))
+
+	draw.Draw(img,
+		image.Rect(inner.Min.X, inner.Max.Y-height, inner.Max.X, inner.Max.Y),
+		image.NewUniform(fillColor),
+		image.Point{}, draw.Over)
+}
+
+// draws the outline of r and returns the area inside of it, leaving a gap.
+func drawFrame(img *image.RGBA, r image.Rectangle, clr color.Color) image.Rectangle {
+	border := gaugeBorder(r)
+	drawBorder(img, r, border, clr)
+
+	return r.Inset(2 * border)
+}
+
+// drawBorder draws the outline of r with the given width.
+func drawBorder(img *image.RGBA, r image.Rectangle, width int, clr color.Color) {
+	src := image.NewUniform(clr)
+
+	for _, edge := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width),
+		image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y+width, r.Min.X+width, r.Max.Y-width),
+		image.Rect(r.Max.X-width, r.Min.Y+width, r.Max.X, r.Max.Y-width),
+	} {
+		draw.Draw(img, edge, src, image.Point{}, draw.Over)
+	}
+}
+
+// drawArc draws value as a ring, open at the bottom, filling up clockwise.
+func drawArc(img *image.RGBA, r image.Rectangle, value float64, trackColor, fillColor color.Color) {
+	// the ring spans 270°, starting at the bottom left
+	sweep := 270 * value / 100
+	fillCircle(img, r, func(dist, angle float64) color.Color {
+		if dist < 1-arcThickness || dist > 1 {
+			return nil
+		}
+
+		d := math.Mod(225-angle+360, 360)
+		switch {
+		case d <= sweep && value > 0:
+			return fillColor
+		case d <= 270:
+			return trackColor
+		}
+		return nil
+	})
+}
+
+// drawPie draws value as a pie segment, starting at the top and filling up
+// clockwise.
+func drawPie(img *image.RGBA, r image.Rectangle, value float64, trackColor, fillColor color.Color) {
+	sweep := 360 * value / 100
+	fillCircle(img, r, func(dist, angle float64) color.Color {
+		if dist > 1 {
+			return nil
+		}
+
+		if d := math.Mod(90-angle+360, 360); d <= sweep && value > 0 {
+			return fillColor
+		}
+		return trackColor
+	})
+}
+
+// fillCircle paints the circle centered in r. For each point, colorAt gets
+// its distance from the center relative to the radius, and its angle in
+// degrees counter-clockwise from the right. It returns the color of the
+// point, or nil to leave it alone. Edges get smoothed by sampling each pixel
+// several times.
+func fillCircle(img *image.RGBA, r image.Rectangle, colorAt func(dist, angle float64) color.Color) {
+	const samples = 4
+
+	radius := float64(r.Dx()) / 2
+	if dy := float64(r.Dy()) / 2; dy < radius {
+		radius = dy
+	}
+	if radius <= 0 {
+		return
+	}
+	cx := float64(r.Min.X) + float64(r.Dx())/2
+	cy := float64(r.Min.Y) + float64(r.Dy())/2
+
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// the colors covering the pixel, and how many samples they cover
+			var colors []color.Color
+			var counts []int
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					dx := float64(x) + (float64(sx)+0.5)/samples - cx
+					dy := cy - (float64(y) + (float64(sy)+0.5)/samples)
+
+					angle := math.Atan2(dy, dx) * 180 / math.Pi
+					c := colorAt(math.Hypot(dx, dy)/radius, angle)
+					if c == nil {
+						continue
+					}
+
+					i := 0
+					for i < len(colors) && colors[i] != c {
+						i++
+					}
+					if i == len(colors) {
+						colors = append(colors, c)
+						counts = append(counts, 0)
+					}
+					counts[i]++
+				}
+			}
+
+			for i, c := range colors {
+				mask := image.NewUniform(color.Alpha{uint8(255 * counts[i] / (samples * samples))})
+				draw.DrawMask(img, image.Rect(x, y, x+1, y+1), image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+			}
+		}
+	}
+}
+
+// returns a darker version of clr.
+func dim(clr color.Color) color.Color {
+	r, g, b, a := clr.RGBA()
+	return color.RGBA64{uint16(r / 3), uint16(g / 3), uint16(b / 3), uint16(a)}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestDrawGauge(t *testing.T) {
+	tests := []struct {
+		style string
+		value float64
+	}{
+		{"hbar", 65},
+		{"vbar", 65},
+		{"arc", 65},
+		{"pie", 65},
+		{"arc", 0},
+		{"pie", 100},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s_%.0f", tt.style, tt.value), func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				size := int(dev.Pixels())
+				img := image.NewRGBA(image.Rect(0, 0, size, size))
+				drawGauge(img, img.Bounds().Inset(size/8), tt.style, tt.value, DefaultColor, DefaultFillColor)
+
+				assertGolden(t, fmt.Sprintf("gauge_%s_%.0f_%d", tt.style, tt.value, size), img)
+			})
+		})
+	}
+
+	if err := checkGaugeStyle("donut"); err == nil {
+		t.Error("expected error for unknown style")
+	}
+}
+
+func TestCommandGauge(t *testing.T) {
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		size := dev.Pixels()
+		w := newTestWidget(t, dev, "command", map[string]interface{}{
+			"command": "echo 42;echo '42%'",
+			"gauge":   "arc",
+			"layout":  fmt.Sprintf("0x0+%dx%d;0x%d+%dx%d", size, size, size/3, size, size/3),
+		})
+		if err := w.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		assertGolden(t, fmt.Sprintf("command_gauge_%d", size), dev.KeyImage(0))
+	})
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	if _, err := NewWidget(dev, "decks/main.deck", KeyConfig{Widget: WidgetConfig{
+		ID:     "command",
+		Config: map[string]interface{}{"command": "echo 1", "gauge": "donut"},
+	}}, nil); err == nil {
+		t.Error("expected error for unknown gauge style")
+	}
+}
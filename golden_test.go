@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/freetype"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenModels are the virtual devices every golden test renders on, covering
+// key sizes of 72, 80 and 96 pixels.
+var goldenModels = []string{"original", "mini", "xl"}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	// use the embedded Go fonts, so rendering doesn't depend on the fonts
+	// installed on the system
+	var err error
+	ttfFont, err = freetype.ParseFont(goregular.TTF)
+	if err != nil {
+		panic(err)
+	}
+	ttfThinFont, err = freetype.ParseFont(gomedium.TTF)
+	if err != nil {
+		panic(err)
+	}
+	ttfBoldFont, err = freetype.ParseFont(gobold.TTF)
+	if err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// forEachModel runs fn as a subtest for each of the golden models.
+func forEachModel(t *testing.T, fn func(t *testing.T, dev *VirtualDevice)) {
+	t.Helper()
+
+	for _, name := range goldenModels {
+		model, err := ParseVirtualModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(fmt.Sprintf("%dpx", model.Pixels), func(t *testing.T) {
+			fn(t, NewVirtualDevice(model, ""))
+		})
+	}
+}
+
+// assertGolden compares img to the golden file testdata/golden/[name].png.
+// When running with -update, the golden file gets (re-)written instead.
+func assertGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name+".png")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writePNG(path, img); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := loadImage(path)
+	if err != nil {
+		t.Fatalf("can't load golden file (run with -update to create it): %s", err)
+	}
+
+	// round-trip the image through PNG, as encoding translucent pixels loses
+	// precision
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := toRGBA(golden)
+	got := toRGBA(encoded)
+	if want.Bounds() != got.Bounds() {
+		t.Fatalf("%s: expected bounds %v, got %v", name, want.Bounds(), got.Bounds())
+	}
+
+	var diff int
+	for y := got.Bounds().Min.Y; y < got.Bounds().Max.Y; y++ {
+		for x := got.Bounds().Min.X; x < got.Bounds().Max.X; x++ {
+			if got.RGBAAt(x, y) != want.RGBAAt(x, y) {
+				diff++
+			}
+		}
+	}
+	if diff == 0 {
+		return
+	}
+
+	actual := filepath.Join(t.TempDir(), filepath.Base(path))
+	if err := writePNG(actual, img); err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("%s: %d pixels differ from golden file, actual image written to %s", name, diff, actual)
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
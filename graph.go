@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// the number of samples a graph shows by default.
+const defaultGraphHistory = 30
+
+// graphConfig lists the config values understood by widgets that can render
+// a graph.
+var graphConfig = map[string]configType{
+	"graph":      configString,
+	"history":    configInt,
+	"graphMin":   configFloat,
+	"graphMax":   configFloat,
+	"graphColor": configColor,
+}
+
+// history is a ring buffer keeping the most recent samples of a value.
+type history struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newHistory(size int) *history {
+	if size < 2 {
+		size = 2
+	}
+
+	return &history{
+		samples: make([]float64, size),
+	}
+}
+
+// add appends a sample, dropping the oldest one if the buffer is full.
+func (h *history) add(v float64) {
+	h.samples[h.next] = v
+	h.next = (h.next + 1) % len(h.samples)
+	h.full = h.full || h.next == 0
+}
+
+// values returns the samples, oldest first.
+func (h *history) values() []float64 {
+	if !h.full {
+		return append([]float64{}, h.samples[:h.next]...)
+	}
+
+	return append(append([]float64{}, h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+// graph renders the history of a value as a sparkline or area chart.
+type graph struct {
+	style   string // line or area
+	history *history
+	color   color.Color
+
+	// the range of the graph. Without one, it spans the samples' range.
+	min, max       float64
+	hasMin, hasMax bool
+}
+
+// newGraph returns the graph configured by the graph settings in config, or
+// nil if the widget shouldn't render one. min and max are the range used
+// unless configured otherwise, and NaN to scale the graph to the samples.
+func newGraph(config map[string]interface{}, min, max float64, clr color.Color) (*graph, error) {
+	var style string
+	_ = ConfigValue(config["graph"], &style)
+	if style == "" {
+		return nil, nil
+	}
+	if err := checkGraphStyle(style); err != nil {
+		return nil, err
+	}
+
+	size := int64(defaultGraphHistory)
+	_ = ConfigValue(config["history"], &size)
+	_ = ConfigValue(config["graphMin"], &min)
+	_ = ConfigValue(config["graphMax"], &max)
+	_ = ConfigValue(config["graphColor"], &clr)
+
+	return &graph{
+		style:   style,
+		history: newHistory(int(size)),
+		color:   clr,
+		min:     min,
+		max:     max,
+		hasMin:  !math.IsNaN(min),
+		hasMax:  !math.IsNaN(max),
+	}, nil
+}
+
+// checkGraphStyle returns an error if style isn't a known graph style.
+func checkGraphStyle(style string) error {
+	switch style {
+	case "line", "area":
+		return nil
+	}
+
+	return fmt.Errorf("unknown graph style %s, expected line or area", style)
+}
+
+// add records a sample.
+func (g *graph) add(v float64) {
+	g.history.add(v)
+}
+
+// returns the range of the graph.
+func (g *graph) bounds(values []float64) (float64, float64) {
+	min, max := g.min, g.max
+	for i, v := range values {
+		if !g.hasMin && (i == 0 || v < min) {
+			min = v
+		}
+		if !g.hasMax && (i == 0 || v > max) {
+			max = v
+		}
+	}
+
+	// auto-scaled graphs start at zero, unless there are negative samples
+	if !g.hasMin && min > 0 {
+		min = 0
+	}
+	if max <= min {
+		max = min + 1
+	}
+
+	return min, max
+}
+
+// draw renders the graph into r. The newest sample is on the right, and the
+// graph fills up from there.
+func (g *graph) draw(img *image.RGBA, r image.Rectangle) {
+	values := g.history.values()
+	if len(values) == 0 || r.Dx() < 2 || r.Dy() < 1 {
+		return
+	}
+	min, max := g.bounds(values)
+
+	// the y coordinate of a sample
+	y := func(v float64) float64 {
+		v = math.Max(min, math.Min(max, v))
+		return float64(r.Max.Y-1) - (v-min)/(max-min)*float64(r.Dy()-1)
+	}
+
+	// the graph always spans the whole history, with the samples recorded
+	// so far on its right
+	step := float64(r.Dx()-1) / float64(len(g.history.samples)-1)
+	offset := len(g.history.samples) - len(values)
+
+	// the y coordinate of the graph at column x
+	at := func(x int) float64 {
+		pos := float64(x-r.Min.X)/step - float64(offset)
+		i := int(math.Floor(pos))
+		if i < 0 {
+			return y(values[0])
+		}
+		if i >= len(values)-1 {
+			return y(values[len(values)-1])
+		}
+		frac := pos - float64(i)
+		return y(values[i])*(1-frac) + y(values[i+1])*frac
+	}
+
+	thickness := r.Dy()/36 + 1
+	line := image.NewUniform(g.color)
+	r0, g0, b0, _ := g.color.RGBA()
+	fill := image.NewUniform(color.RGBA64{uint16(r0 / 2), uint16(g0 / 2), uint16(b0 / 2), 0x7fff})
+
+	first := r.Min.X + int(math.Ceil(float64(offset)*step))
+	for x := first; x < r.Max.X; x++ {
+		top := int(math.Round(at(x)))
+		next := top
+		if x+1 < r.Max.X {
+			next = int(math.Round(at(x + 1)))
+		}
+
+		if g.style == "area" {
+			draw.Draw(img, image.Rect(x, top, x+1, r.Max.Y), fill, image.Point{}, draw.Over)
+		}
+
+		// connect the column to the next one, so steep slopes don't leave gaps
+		from, to := top, next
+		if from > to {
+			from, to = to, from
+		}
+		draw.Draw(img,
+			image.Rect(x, from-thickness/2, x+1, to+thickness-thickness/2).Intersect(r),
+			line, image.Point{}, draw.Over)
+	}
+}
+
+// drawGraph draws a graph filling most of the key, with text on top of it and
+// a label underneath.
+func drawGraph(img *image.RGBA, dpi uint, g *graph, text, label string, clr color.Color) {
+	size := img.Bounds().Dx()
+	margin := size / 18
+	bottom := size * 3 / 4
+
+	g.draw(img, image.Rect(margin, margin, size-margin, bottom))
+
+	drawString(img,
+		image.Rect(margin, margin, size-margin, margin+size/3),
+		ttfFont,
+		text,
+		dpi,
+		-1,
+		clr,
+		image.Pt(-1, -1))
+
+	drawString(img,
+		image.Rect(0, bottom+margin, size, size-margin),
+		ttfFont,
+		label,
+		dpi,
+		-1,
+		clr,
+		image.Pt(-1, -1))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3)
+	if v := h.values(); len(v) != 0 {
+		t.Errorf("expected no values, got %v", v)
+	}
+
+	tests := []struct {
+		add  float64
+		want []float64
+	}{
+		{1, []float64{1}},
+		{2, []float64{1, 2}},
+		{3, []float64{1, 2, 3}},
+		{4, []float64{2, 3, 4}},
+		{5, []float64{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		h.add(tt.add)
+		if v := h.values(); !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("after adding %v: expected %v, got %v", tt.add, tt.want, v)
+		}
+	}
+}
+
+func TestGraphBounds(t *testing.T) {
+	tests := []struct {
+		config   map[string]interface{}
+		min, max float64
+		values   []float64
+		wantMin  float64
+		wantMax  float64
+	}{
+		{map[string]interface{}{}, 0, 100, []float64{20, 40}, 0, 100},
+		{map[string]interface{}{}, math.NaN(), math.NaN(), []float64{20, 40}, 0, 40},
+		{map[string]interface{}{}, math.NaN(), math.NaN(), []float64{-5, 5}, -5, 5},
+		{map[string]interface{}{}, math.NaN(), math.NaN(), []float64{0, 0}, 0, 1},
+		{map[string]interface{}{"graphMin": int64(10), "graphMax": 50.0}, 0, 100, []float64{20, 40}, 10, 50},
+		{map[string]interface{}{"graphMin": int64(30)}, math.NaN(), math.NaN(), []float64{20, 40}, 30, 40},
+	}
+
+	for i, tt := range tests {
+		tt.config["graph"] = "line"
+		g, err := newGraph(tt.config, tt.min, tt.max, DefaultFillColor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		min, max := g.bounds(tt.values)
+		if min != tt.wantMin || max != tt.wantMax {
+			t.Errorf("test %d: expected range %v-%v, got %v-%v", i, tt.wantMin, tt.wantMax, min, max)
+		}
+	}
+
+	if g, err := newGraph(map[string]interface{}{}, 0, 100, DefaultFillColor); g != nil || err != nil {
+		t.Errorf("expected no graph without a style, got %v (%v)", g, err)
+	}
+	if _, err := newGraph(map[string]interface{}{"graph": "bars"}, 0, 100, DefaultFillColor); err == nil {
+		t.Error("expected error for unknown style")
+	}
+}
+
+func TestDrawGraph(t *testing.T) {
+	samples := []float64{10, 25, 20, 60, 55, 90, 70, 40, 45, 30}
+
+	for _, style := range []string{"line", "area"} {
+		style := style
+		t.Run(style, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "top", map[string]interface{}{
+					"mode":    "cpu",
+					"graph":   style,
+					"history": int64(12),
+				}).(*TopWidget)
+				for _, s := range samples {
+					w.graph.add(s)
+				}
+				if err := w.renderValue(30, "CPU"); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("graph_%s_%d", style, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestCommandGraph(t *testing.T) {
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	w := newTestWidget(t, dev, "command", map[string]interface{}{
+		"command": "echo 'load: 0.75'",
+		"graph":   "line",
+	}).(*CommandWidget)
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, output := range []string{"no number", "-3 degrees"} {
+		if v, ok := w.number(0, output); ok {
+			w.graph.add(v)
+		}
+	}
+
+	if v := w.graph.history.values(); !reflect.DeepEqual(v, []float64{0.75, 0.75, -3}) {
+		t.Errorf("expected samples [0.75 0.75 -3], got %v", v)
+	}
+
+	// frames without any width don't get drawn into
+	img := image.NewRGBA(image.Rect(0, 0, 72, 72))
+	w.graph.draw(img, image.Rect(4, 4, 4, 68))
+	for _, p := range img.Pix {
+		if p != 0 {
+			t.Fatal("expected empty image")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// IdleEvent gets emitted periodically with the time since the last user input.
+type IdleEvent struct {
+	Idle time.Duration
+}
+
+// ScreenSaverEvent gets emitted when the screensaver gets activated or
+// deactivated.
+type ScreenSaverEvent struct {
+	Active bool
+}
+
+var (
+	// the time the session needs to be idle before the device goes to sleep,
+	// 0 to disable
+	idleTimeout time.Duration
+
+	// the device got put to sleep because the session is idle
+	idleSleep bool
+	// the screensaver is active
+	screensaverActive bool
+)
+
+// handleIdle puts the device to sleep while the session is idle or the
+// screensaver is active, and wakes it up again on user activity.
+func handleIdle(dev Device, idle time.Duration) {
+	switch {
+	case idleTimeout > 0 && idle >= idleTimeout, screensaverActive:
+		sleepIdle(dev, "Session is idle")
+
+	case idleSleep:
+		// the device stays off over a locked session, handleLock wakes it up
+		if sessionLocked {
+			return
+		}
+
+		idleSleep = false
+		if dev.Asleep() {
+			verbosef("Session is active again, waking device up")
+			if err := dev.Wake(); err != nil {
+				fmt.Fprintf(os.Stderr, "Can't wake device up: %s\n", err)
+			}
+		}
+	}
+}
+
+// handleScreenSaver puts the device to sleep when the screensaver gets
+// activated. It wakes up again on user activity, see handleIdle.
+func handleScreenSaver(dev Device, event ScreenSaverEvent) {
+	verbosef("Screensaver active: %t", event.Active)
+	screensaverActive = event.Active
+	if screensaverActive {
+		sleepIdle(dev, "Screensaver is active")
+	}
+}
+
+// puts the device to sleep, unless it already got put to sleep during this
+// idle period. That way keys can still wake it up.
+func sleepIdle(dev Device, reason string) {
+	if idleSleep {
+		return
+	}
+
+	idleSleep = true
+	if !dev.Asleep() {
+		verbosef("%s, putting device to sleep", reason)
+		if err := dev.Sleep(); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't put device to sleep: %s\n", err)
+		}
+	}
+}
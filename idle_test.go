@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandleIdle(t *testing.T) {
+	defer func(timeout time.Duration) {
+		idleTimeout, idleSleep, screensaverActive = timeout, false, false
+	}(idleTimeout)
+	idleTimeout = 5 * time.Minute
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	steps := []struct {
+		name        string
+		idle        time.Duration
+		screensaver *bool
+		press       bool
+		asleep      bool
+	}{
+		{name: "active", idle: time.Second},
+		{name: "idle", idle: 6 * time.Minute, asleep: true},
+		{name: "woken by key", press: true},
+		{name: "still idle", idle: 7 * time.Minute},
+		{name: "active again", idle: time.Second},
+		{name: "screensaver on", screensaver: boolPtr(true), asleep: true},
+		{name: "screensaver still on", idle: time.Second, asleep: true},
+		{name: "screensaver off", screensaver: boolPtr(false), asleep: true},
+		{name: "user input", idle: time.Second},
+	}
+
+	for _, s := range steps {
+		switch {
+		case s.screensaver != nil:
+			handleScreenSaver(dev, ScreenSaverEvent{Active: *s.screensaver})
+		case s.press:
+			// the hardware wakes up on its own when a key gets pressed
+			_ = dev.Wake()
+		default:
+			handleIdle(dev, s.idle)
+		}
+
+		if dev.Asleep() != s.asleep {
+			t.Errorf("%s: expected device to be asleep: %t", s.name, s.asleep)
+		}
+	}
+}
+
+func TestHandleIdleLocked(t *testing.T) {
+	defer func(timeout time.Duration) {
+		idleTimeout, idleSleep, screensaverActive, sessionLocked = timeout, false, false, false
+	}(idleTimeout)
+	idleTimeout = 5 * time.Minute
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	steps := []struct {
+		name   string
+		idle   time.Duration
+		locked *bool
+		asleep bool
+	}{
+		{name: "idle", idle: 6 * time.Minute, asleep: true},
+		{name: "locked", locked: boolPtr(true), asleep: true},
+		{name: "activity while locked", idle: time.Second, asleep: true},
+		{name: "unlocked", locked: boolPtr(false)},
+		{name: "activity", idle: time.Second},
+	}
+
+	for _, s := range steps {
+		if s.locked != nil {
+			handleLock(dev, *s.locked)
+		} else {
+			handleIdle(dev, s.idle)
+		}
+
+		if dev.Asleep() != s.asleep {
+			t.Errorf("%s: expected device to be asleep: %t", s.name, s.asleep)
+		}
+	}
+}
+
+func TestHandleScreenSaverWithoutIdleTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		idleTimeout, idleSleep, screensaverActive = timeout, false, false
+	}(idleTimeout)
+	idleTimeout = 0
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	steps := []struct {
+		name        string
+		idle        time.Duration
+		screensaver *bool
+		asleep      bool
+	}{
+		{name: "idle", idle: time.Hour},
+		{name: "screensaver on", screensaver: boolPtr(true), asleep: true},
+		{name: "screensaver still on", idle: time.Second, asleep: true},
+		{name: "screensaver off", screensaver: boolPtr(false), asleep: true},
+		{name: "user input", idle: time.Second},
+	}
+
+	for _, s := range steps {
+		if s.screensaver != nil {
+			handleScreenSaver(dev, ScreenSaverEvent{Active: *s.screensaver})
+		} else {
+			handleIdle(dev, s.idle)
+		}
+
+		if dev.Asleep() != s.asleep {
+			t.Errorf("%s: expected device to be asleep: %t", s.name, s.asleep)
+		}
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -257,3 +258,36 @@ func formatKeycodes(keycode string) string {
 
 	return keycode
 }
+
+// parseKeycode converts a key name or a numeric keycode to a keycode.
+func parseKeycode(keycode string) (int, error) {
+	kc, err := strconv.Atoi(formatKeycodes(keycode))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid keycode", keycode)
+	}
+
+	return kc, nil
+}
+
+// parseKeyPresses returns all keycodes used in a range of key presses.
+func parseKeyPresses(keys string) ([]int, error) {
+	var kcs []int
+	for _, kp := range strings.Split(keys, "/") {
+		kd := strings.Split(kp, "+")
+		if len(kd) > 1 {
+			if _, err := strconv.Atoi(strings.TrimSpace(kd[1])); err != nil {
+				return nil, fmt.Errorf("%s is not a valid delay", strings.TrimSpace(kd[1]))
+			}
+		}
+
+		for _, k := range strings.Split(kd[0], "-") {
+			kc, err := parseKeycode(strings.TrimSpace(k))
+			if err != nil {
+				return nil, err
+			}
+			kcs = append(kcs, kc)
+		}
+	}
+
+	return kcs, nil
+}
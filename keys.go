@@ -0,0 +1,321 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// doubleTapDuration is the time to wait for a second tap on keys with a
+	// double-tap action.
+	doubleTapDuration = 250 * time.Millisecond
+
+	// defaultRepeatInterval is the time between two actions of a repeating
+	// key.
+	defaultRepeatInterval = 100 * time.Millisecond
+
+	// defaultChordWindow is the time in which all keys of a chord need to be
+	// pressed.
+	defaultChordWindow = 100 * time.Millisecond
+)
+
+// gesture is a way of interacting with a key.
+type gesture int
+
+const (
+	gesturePress   gesture = iota // short press
+	gestureHold                   // key held down
+	gestureDouble                 // two short presses in a row
+	gestureRelease                // key released after being held
+)
+
+func (g gesture) String() string {
+	switch g {
+	case gesturePress:
+		return "short"
+	case gestureHold:
+		return "long"
+	case gestureDouble:
+		return "double-tap"
+	case gestureRelease:
+		return "release"
+	}
+
+	return "unknown"
+}
+
+// keyBehavior describes how a key reacts to gestures.
+type keyBehavior struct {
+	holdTime    time.Duration
+	doubleTap   bool
+	repeat      time.Duration // 0 if the key doesn't repeat
+	repeatDelay time.Duration
+}
+
+// chord is a set of keys triggering an action when pressed together.
+type chord struct {
+	keys   []uint8
+	window time.Duration
+	action *ActionConfig
+}
+
+// keyBindings tells a keyTracker how keys behave and receives the gestures it
+// recognizes.
+type keyBindings interface {
+	Behavior(index uint8) keyBehavior
+	Chords() []chord
+	Trigger(index uint8, g gesture)
+	TriggerChord(c chord)
+}
+
+// timerKind identifies the timers of the key state machine.
+type timerKind int
+
+const (
+	timerHold timerKind = iota
+	timerDoubleTap
+	timerRepeat
+)
+
+// keyTimer is an expired timer of the key state machine.
+type keyTimer struct {
+	index uint8
+	kind  timerKind
+	seq   uint64
+}
+
+// keyState is the state of a single key.
+type keyState struct {
+	pressed   bool
+	since     time.Time // when the key got pressed
+	held      bool      // the hold action got triggered, or the key repeated
+	tapped    bool      // waiting for a second tap
+	repeating bool      // the key repeats its action while pressed
+	chorded   bool      // the key is part of a triggered chord
+	seq       uint64    // timers with another sequence number are stale
+}
+
+// keyTracker turns the presses and releases of keys into gestures. It must
+// only be used from the event loop, which feeds expired timers back to it.
+type keyTracker struct {
+	keys     map[uint8]*keyState
+	seq      uint64
+	timers   chan keyTimer
+	bindings keyBindings
+}
+
+// newKeyTracker returns a new keyTracker for bindings.
+func newKeyTracker(bindings keyBindings) *keyTracker {
+	return &keyTracker{
+		keys:     make(map[uint8]*keyState),
+		timers:   make(chan keyTimer, 16),
+		bindings: bindings,
+	}
+}
+
+// Pressed returns true while a key is pressed.
+func (t *keyTracker) Pressed(index uint8) bool {
+	if s, ok := t.keys[index]; ok {
+		return s.pressed
+	}
+
+	return false
+}
+
+// handle processes a key press or release.
+func (t *keyTracker) handle(index uint8, pressed bool) {
+	s := t.state(index)
+	if s.pressed == pressed {
+		return
+	}
+	s.pressed = pressed
+	b := t.bindings.Behavior(index)
+
+	if pressed {
+		s.since = time.Now()
+		s.held = false
+		s.chorded = false
+		if t.chord(index) {
+			return
+		}
+
+		s.repeating = b.repeat > 0
+		if s.repeating {
+			// repeating keys trigger right away
+			s.tapped = false
+			t.bindings.Trigger(index, gesturePress)
+			t.start(s, index, timerRepeat, b.repeatDelay)
+			return
+		}
+
+		t.start(s, index, timerHold, b.holdTime)
+		return
+	}
+
+	// key was released, stop waiting for it to be held or to repeat
+	t.cancel(s)
+
+	switch {
+	case s.chorded:
+		s.chorded = false
+
+	case s.repeating:
+		s.repeating = false
+		if s.held {
+			s.held = false
+			t.bindings.Trigger(index, gestureRelease)
+		}
+
+	case s.held:
+		s.held = false
+		t.bindings.Trigger(index, gestureRelease)
+
+	case !b.doubleTap:
+		t.bindings.Trigger(index, gesturePress)
+
+	case s.tapped:
+		s.tapped = false
+		t.bindings.Trigger(index, gestureDouble)
+
+	default:
+		s.tapped = true
+		t.start(s, index, timerDoubleTap, doubleTapDuration)
+	}
+}
+
+// fire processes an expired timer.
+func (t *keyTracker) fire(kt keyTimer) {
+	s, ok := t.keys[kt.index]
+	if !ok || s.seq != kt.seq {
+		return
+	}
+
+	switch kt.kind {
+	case timerHold:
+		if s.tapped {
+			// the first tap never got its second one
+			s.tapped = false
+			t.bindings.Trigger(kt.index, gesturePress)
+		}
+		s.held = true
+		t.bindings.Trigger(kt.index, gestureHold)
+
+	case timerDoubleTap:
+		s.tapped = false
+		t.bindings.Trigger(kt.index, gesturePress)
+
+	case timerRepeat:
+		// the key may have lost its repeat setting, e.g. by switching decks
+		b := t.bindings.Behavior(kt.index)
+		if b.repeat == 0 {
+			return
+		}
+
+		s.held = true
+		t.bindings.Trigger(kt.index, gesturePress)
+		t.start(s, kt.index, timerRepeat, b.repeat)
+	}
+}
+
+// triggers a chord completed by pressing a key. It returns false if the key
+// doesn't complete any chord.
+func (t *keyTracker) chord(index uint8) bool {
+	now := time.Now()
+
+	for _, c := range t.bindings.Chords() {
+		complete := false
+		for _, k := range c.keys {
+			if k == index {
+				complete = true
+				break
+			}
+		}
+
+		for _, k := range c.keys {
+			s, ok := t.keys[k]
+			if !complete || !ok || !s.pressed || s.chorded || now.Sub(s.since) > c.window {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			continue
+		}
+
+		// suppress all other gestures of the chord's keys
+		for _, k := range c.keys {
+			s := t.keys[k]
+			t.cancel(s)
+			s.chorded = true
+			s.held = false
+			s.tapped = false
+			s.repeating = false
+		}
+		t.bindings.TriggerChord(c)
+		return true
+	}
+
+	return false
+}
+
+// reset forgets the state of all keys, e.g. after the device got
+// disconnected. Pending timers become stale.
+func (t *keyTracker) reset() {
+	t.keys = make(map[uint8]*keyState)
+}
+
+func (t *keyTracker) state(index uint8) *keyState {
+	s, ok := t.keys[index]
+	if !ok {
+		s = &keyState{}
+		t.keys[index] = s
+	}
+
+	return s
+}
+
+// starts a timer for a key, replacing its pending timer.
+func (t *keyTracker) start(s *keyState, index uint8, kind timerKind, d time.Duration) {
+	t.cancel(s)
+
+	kt := keyTimer{
+		index: index,
+		kind:  kind,
+		seq:   s.seq,
+	}
+	time.AfterFunc(d, func() {
+		t.timers <- kt
+	})
+}
+
+// cancels the pending timer of a key.
+func (t *keyTracker) cancel(s *keyState) {
+	t.seq++
+	s.seq = t.seq
+}
+
+// currentDeck binds a keyTracker to whichever deck is currently shown.
+type currentDeck struct {
+	dev Device
+}
+
+// Behavior returns how a key of the current deck reacts to gestures.
+func (c currentDeck) Behavior(index uint8) keyBehavior {
+	return deck.keyBehavior(index)
+}
+
+// Chords returns the chords of the current deck.
+func (c currentDeck) Chords() []chord {
+	return deck.chords
+}
+
+// Trigger triggers the action of a key of the current deck.
+func (c currentDeck) Trigger(index uint8, g gesture) {
+	verbosef("Triggering %s action for key %d", g, index)
+	deck.triggerGesture(c.dev, index, g)
+}
+
+// TriggerChord triggers the action of a chord of the current deck.
+func (c currentDeck) TriggerChord(ch chord) {
+	verbosef("Triggering chord %v", ch.keys)
+	deck.runAction(c.dev, -1, ch.action)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBindings records the gestures recognized by a keyTracker.
+type testBindings struct {
+	behavior func(index uint8) keyBehavior
+	chords   []chord
+	gestures *[]gesture
+	keys     []uint8
+	triggers []chord
+}
+
+func (b *testBindings) Behavior(index uint8) keyBehavior {
+	return b.behavior(index)
+}
+
+func (b *testBindings) Chords() []chord {
+	return b.chords
+}
+
+func (b *testBindings) Trigger(index uint8, g gesture) {
+	*b.gestures = append(*b.gestures, g)
+	b.keys = append(b.keys, index)
+}
+
+func (b *testBindings) TriggerChord(c chord) {
+	b.triggers = append(b.triggers, c)
+}
+
+func TestKeyTracker(t *testing.T) {
+	type event struct {
+		index   uint8
+		pressed bool
+		wait    time.Duration // process timers for this long before the event
+	}
+
+	tests := []struct {
+		name   string
+		double bool
+		events []event
+		want   []gesture
+	}{
+		{"short", false, []event{
+			{0, true, 0},
+			{0, false, 0},
+		}, []gesture{gesturePress}},
+		{"hold", false, []event{
+			{0, true, 0},
+			{0, false, 80 * time.Millisecond},
+		}, []gesture{gestureHold, gestureRelease}},
+		{"double tap", true, []event{
+			{0, true, 0},
+			{0, false, 0},
+			{0, true, 0},
+			{0, false, 0},
+		}, []gesture{gestureDouble}},
+		{"single tap with double-tap action", true, []event{
+			{0, true, 0},
+			{0, false, 0},
+			{1, true, doubleTapDuration + 50*time.Millisecond},
+		}, []gesture{gesturePress}},
+		{"tap and hold", true, []event{
+			{0, true, 0},
+			{0, false, 0},
+			{0, true, 0},
+			{0, false, 80 * time.Millisecond},
+		}, []gesture{gesturePress, gestureHold, gestureRelease}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got []gesture
+			tr := newKeyTracker(&testBindings{
+				behavior: func(index uint8) keyBehavior {
+					return keyBehavior{
+						holdTime:  50 * time.Millisecond,
+						doubleTap: tt.double,
+					}
+				},
+				gestures: &got,
+			})
+
+			for _, e := range tt.events {
+				deadline := time.After(e.wait)
+			wait:
+				for e.wait > 0 {
+					select {
+					case kt := <-tr.timers:
+						tr.fire(kt)
+					case <-deadline:
+						break wait
+					}
+				}
+
+				tr.handle(e.index, e.pressed)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected gestures %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyTrackerReset(t *testing.T) {
+	var got []gesture
+	tr := newKeyTracker(&testBindings{
+		behavior: func(index uint8) keyBehavior {
+			return keyBehavior{holdTime: 10 * time.Millisecond}
+		},
+		gestures: &got,
+	})
+
+	tr.handle(0, true)
+	if !tr.Pressed(0) {
+		t.Fatal("expected key to be pressed")
+	}
+	tr.reset()
+	if tr.Pressed(0) {
+		t.Fatal("expected key to be released after a reset")
+	}
+
+	// the hold timer started before the reset must not fire
+	tr.fire(<-tr.timers)
+	if len(got) > 0 {
+		t.Errorf("expected no gestures, got %v", got)
+	}
+}
+
+func TestKeyTrackerRepeat(t *testing.T) {
+	var got []gesture
+	tr := newKeyTracker(&testBindings{
+		behavior: func(index uint8) keyBehavior {
+			return keyBehavior{
+				holdTime:    50 * time.Millisecond,
+				repeat:      10 * time.Millisecond,
+				repeatDelay: 30 * time.Millisecond,
+			}
+		},
+		gestures: &got,
+	})
+
+	process := func(d time.Duration) {
+		deadline := time.After(d)
+		for {
+			select {
+			case kt := <-tr.timers:
+				tr.fire(kt)
+			case <-deadline:
+				return
+			}
+		}
+	}
+
+	tr.handle(0, true)
+	if len(got) != 1 || got[0] != gesturePress {
+		t.Fatalf("expected an immediate press, got %v", got)
+	}
+
+	process(100 * time.Millisecond)
+	tr.handle(0, false)
+	n := len(got)
+	if n < 4 {
+		t.Fatalf("expected the key to repeat, got %v", got)
+	}
+	for _, g := range got[:n-1] {
+		if g != gesturePress {
+			t.Fatalf("expected only presses while holding the key, got %v", got)
+		}
+	}
+	if got[n-1] != gestureRelease {
+		t.Fatalf("expected a release, got %v", got)
+	}
+
+	// no more repeats after the key got released
+	process(50 * time.Millisecond)
+	if len(got) != n {
+		t.Errorf("expected the key to stop repeating, got %v", got[n:])
+	}
+
+	// a key released before its first repeat only triggers once
+	got = nil
+	tr.handle(0, true)
+	tr.handle(0, false)
+	process(50 * time.Millisecond)
+	if len(got) != 1 || got[0] != gesturePress {
+		t.Errorf("expected a single press, got %v", got)
+	}
+}
+
+func TestKeyTrackerChord(t *testing.T) {
+	var got []gesture
+	b := &testBindings{
+		behavior: func(index uint8) keyBehavior {
+			return keyBehavior{holdTime: 200 * time.Millisecond}
+		},
+		chords:   []chord{{keys: []uint8{0, 4}, window: 50 * time.Millisecond}},
+		gestures: &got,
+	}
+	tr := newKeyTracker(b)
+
+	// pressing both keys together triggers the chord instead of the keys
+	tr.handle(0, true)
+	tr.handle(4, true)
+	tr.handle(0, false)
+	tr.handle(4, false)
+	if len(b.triggers) != 1 {
+		t.Fatalf("expected the chord to trigger once, got %d", len(b.triggers))
+	}
+	if len(got) > 0 {
+		t.Fatalf("expected the chord to suppress the keys' actions, got %v", got)
+	}
+
+	// pressing the keys too far apart triggers the keys individually
+	tr.handle(0, true)
+	time.Sleep(80 * time.Millisecond)
+	tr.handle(4, true)
+	tr.handle(0, false)
+	tr.handle(4, false)
+	if len(b.triggers) != 1 {
+		t.Fatalf("expected the chord not to trigger again, got %d", len(b.triggers))
+	}
+	if !reflect.DeepEqual(b.keys, []uint8{0, 4}) {
+		t.Errorf("expected short presses of keys 0 and 4, got %v", b.keys)
+	}
+}
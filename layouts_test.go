@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFormatLayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		frameReps []string
+		count     int
+		want      []image.Rectangle
+	}{
+		{"default", 72, nil, 3, []image.Rectangle{
+			image.Rect(0, 4, 72, 25),
+			image.Rect(0, 25, 72, 46),
+			image.Rect(0, 46, 72, 67),
+		}},
+		{"default_96", 96, nil, 2, []image.Rectangle{
+			image.Rect(0, 5, 96, 48),
+			image.Rect(0, 48, 96, 91),
+		}},
+		{"custom", 72, []string{"0x0+72x24", "10x24+52x48"}, 2, []image.Rectangle{
+			image.Rect(0, 0, 72, 24),
+			image.Rect(10, 24, 62, 72),
+		}},
+		{"partial", 72, []string{"0x0+72x20"}, 2, []image.Rectangle{
+			image.Rect(0, 0, 72, 20),
+			image.Rect(0, 36, 72, 68),
+		}},
+		{"invalid", 80, []string{"0x0", "axb+1x1"}, 2, []image.Rectangle{
+			image.Rect(0, 4, 80, 40),
+			image.Rect(0, 40, 80, 76),
+		}},
+		{"no_frames", 72, nil, 0, []image.Rectangle{
+			image.Rect(0, 4, 72, 68),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewLayout(tt.size).FormatLayout(tt.frameReps, tt.count)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d frames, got %d: %v", len(tt.want), len(got), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("frame %d: expected %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLayoutFrames(t *testing.T) {
+	clrs := []color.Color{
+		color.RGBA{212, 151, 222, 255},
+		color.RGBA{166, 155, 182, 255},
+		color.RGBA{255, 255, 255, 255},
+	}
+
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		size := int(dev.Pixels())
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+		frames := NewLayout(size).FormatLayout([]string{"", fmt.Sprintf("0x%d+%dx%d", size/2, size/2, size/2)}, 3)
+		for i, f := range frames {
+			draw.Draw(img, f.Inset(1), image.NewUniform(clrs[i]), image.Point{}, draw.Src)
+		}
+
+		assertGolden(t, fmt.Sprintf("layout_frames_%d", size), img)
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sync"
 	"syscall"
 	"time"
 
@@ -32,20 +31,32 @@ var (
 	keyboard uinput.Keyboard
 	shutdown = make(chan error)
 
-	xorg          *Xorg
+	// steps of action sequences, to be executed by the event loop
+	actionSteps = make(chan func())
+
+	windowTracker WindowTracker
 	recentWindows []Window
 
 	deckFile   = flag.String("deck", "main.deck", "path to deck config file")
 	device     = flag.String("device", "", "which device to use (serial number)")
 	brightness = flag.Uint("brightness", 80, "brightness in percent")
 	sleep      = flag.String("sleep", "", "sleep timeout")
+	idle       = flag.String("idle", "", "put the device to sleep after the session was idle for this long")
+	lockDeck   = flag.String("lock-deck", "", "deck to show while the session is locked (blanks the device if empty)")
+	watch      = flag.Bool("watch", true, "reload the deck when its files change")
+	socket     = flag.String("socket", defaultSocketPath(), "path to the control socket (empty to disable)")
 	verbose    = flag.Bool("verbose", false, "verbose output")
 	version    = flag.Bool("version", false, "display version")
+
+	virtual       = flag.String("virtual", "", "use a virtual device instead of hardware (mini, original, xl or [columns]x[rows]@[pixels])")
+	virtualOutput = flag.String("virtual-output", "", "path to write the virtual device's panel image to (PNG)")
+	virtualScript = flag.String("virtual-script", "", "path to a script of key presses for the virtual device (- for stdin)")
 )
 
 const (
 	fadeDuration      = 250 * time.Millisecond
 	longPressDuration = 350 * time.Millisecond
+	watchInterval     = 500 * time.Millisecond
 )
 
 func fatal(v ...interface{}) {
@@ -81,15 +92,27 @@ func expandPath(base, path string) (string, error) {
 	return filepath.Abs(path)
 }
 
-func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
+func eventLoop(dev Device, tch chan interface{}, cch chan controlCall) error {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	hup := make(chan os.Signal, 1)
 	signal.Notify(hup, syscall.SIGHUP)
 
-	var keyStates sync.Map
-	keyTimestamps := make(map[uint8]time.Time)
+	keys := newKeyTracker(currentDeck{dev: dev})
+
+	// stop the processes run by widgets when shutting down
+	defer func() { deck.close() }()
+
+	// watch the files of the current deck for changes
+	var watched *Deck
+	var watcher *FileWatcher
+	var lastChange time.Time
+	wch := time.NewTicker(watchInterval)
+	defer wch.Stop()
+	if !*watch {
+		wch.Stop()
+	}
 
 	kch, err := dev.ReadKeys()
 	if err != nil {
@@ -100,41 +123,45 @@ func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
 		case <-time.After(100 * time.Millisecond):
 			deck.updateWidgets()
 
+		case <-wch.C:
+			if watched != deck {
+				watched = deck
+				watcher = NewFileWatcher(deck.Files)
+				lastChange = time.Time{}
+				continue
+			}
+
+			// wait until the files stopped changing, editors often write
+			// several times in a row
+			if watcher.Changed() {
+				lastChange = time.Now()
+				continue
+			}
+			if !lastChange.IsZero() {
+				lastChange = time.Time{}
+				verbosef("Deck files changed, reloading configuration...")
+				_ = reloadDeck(dev)
+			}
+
 		case k, ok := <-kch:
 			if !ok {
-				if err = dev.Open(); err != nil {
+				keys.reset()
+				if kch, err = reopenDevice(dev); err != nil {
 					return err
 				}
 				continue
 			}
 
-			var state bool
-			if ks, ok := keyStates.Load(k.Index); ok {
-				state = ks.(bool)
-			}
-			keyStates.Store(k.Index, k.Pressed)
+			keys.handle(k.Index, k.Pressed)
 
-			if state && !k.Pressed {
-				// key was released
-				if time.Since(keyTimestamps[k.Index]) < longPressDuration {
-					verbosef("Triggering short action for key %d", k.Index)
-					deck.triggerAction(dev, k.Index, false)
-				}
-			}
-			if !state && k.Pressed {
-				// key was pressed
-				go func() {
-					// launch timer to observe keystate
-					time.Sleep(longPressDuration)
-
-					if state, ok := keyStates.Load(k.Index); ok && state.(bool) {
-						// key still pressed
-						verbosef("Triggering long action for key %d", k.Index)
-						deck.triggerAction(dev, k.Index, true)
-					}
-				}()
-			}
-			keyTimestamps[k.Index] = time.Now()
+		case kt := <-keys.timers:
+			keys.fire(kt)
+
+		case step := <-actionSteps:
+			step()
+
+		case call := <-cch:
+			call.reply <- handleControl(dev, call.req, keys)
 
 		case e := <-tch:
 			switch event := e.(type) {
@@ -143,6 +170,27 @@ func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
 
 			case ActiveWindowChangedEvent:
 				handleActiveWindowChanged(dev, event)
+				applyRules(dev, event.Window)
+
+			case IdleEvent:
+				handleIdle(dev, event.Idle)
+
+			case ScreenSaverEvent:
+				handleScreenSaver(dev, event)
+
+			case SessionLockEvent:
+				handleLock(dev, event.Locked)
+
+			case SignalEvent:
+				deck.handleSignal(dev, event)
+
+			case ResumeEvent:
+				// the device often loses its state while suspended
+				verbosef("Resumed from suspend, re-opening device...")
+				keys.reset()
+				if kch, err = reopenDevice(dev); err != nil {
+					return err
+				}
 			}
 
 		case err := <-shutdown:
@@ -150,16 +198,7 @@ func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
 
 		case <-hup:
 			verbosef("Received SIGHUP, reloading configuration...")
-
-			nd, err := LoadDeck(dev, ".", deck.File)
-			if err != nil {
-				verbosef("The new configuration is not valid, keeping the current one.")
-				fmt.Fprintf(os.Stderr, "Configuration Error: %s\n", err)
-				continue
-			}
-
-			deck = nd
-			deck.updateWidgets()
+			_ = reloadDeck(dev)
 
 		case <-sigs:
 			fmt.Println("Shutting down...")
@@ -168,7 +207,40 @@ func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
 	}
 }
 
-func closeDevice(dev *streamdeck.Device) {
+// reopenDevice re-opens the device, restores its settings and repaints the
+// current deck. It returns the new key channel.
+func reopenDevice(dev Device) (chan streamdeck.Key, error) {
+	_ = dev.Close()
+	if err := dev.Open(); err != nil {
+		return nil, err
+	}
+	if err := dev.SetBrightness(uint8(*brightness)); err != nil {
+		return nil, err
+	}
+	if err := deck.redraw(dev); err != nil {
+		return nil, err
+	}
+
+	return dev.ReadKeys()
+}
+
+// reloadDeck reloads the current deck, keeping it if the new configuration is
+// invalid.
+func reloadDeck(dev Device) error {
+	nd, err := LoadDeck(dev, ".", deck.File)
+	if err != nil {
+		verbosef("The new configuration is not valid, keeping the current one.")
+		fmt.Fprintf(os.Stderr, "Configuration Error: %s\n", err)
+		return err
+	}
+
+	deck.close()
+	deck = nd
+	deck.updateWidgets()
+	return nil
+}
+
+func closeDevice(dev Device) {
 	if err := dev.Reset(); err != nil {
 		fmt.Fprintln(os.Stderr, "Unable to reset Stream Deck")
 	}
@@ -177,7 +249,16 @@ func closeDevice(dev *streamdeck.Device) {
 	}
 }
 
-func initDevice() (*streamdeck.Device, error) {
+func findDevice() (Device, error) {
+	if len(*virtual) > 0 {
+		model, err := ParseVirtualModel(*virtual)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewVirtualDevice(model, *virtualOutput), nil
+	}
+
 	d, err := streamdeck.Devices()
 	if err != nil {
 		return nil, err
@@ -199,44 +280,81 @@ func initDevice() (*streamdeck.Device, error) {
 		if !found {
 			fmt.Fprintln(os.Stderr, "Can't find device. Available devices:")
 			for _, v := range d {
-				fmt.Fprintf(os.Stderr, "Serial %s (%d buttons)\n", v.Serial, dev.Keys)
+				fmt.Fprintf(os.Stderr, "Serial %s (%d buttons)\n", v.Serial, v.Keys)
 			}
 			os.Exit(1)
 		}
 	}
 
+	return NewStreamDeck(&dev), nil
+}
+
+func initDevice() (Device, error) {
+	dev, err := findDevice()
+	if err != nil {
+		return nil, err
+	}
+
 	if err := dev.Open(); err != nil {
 		return nil, err
 	}
 	ver, err := dev.FirmwareVersion()
 	if err != nil {
-		return &dev, err
+		return dev, err
 	}
 	verbosef("Found device with serial %s (%d buttons, firmware %s)",
-		dev.Serial, dev.Keys, ver)
+		dev.Serial(), dev.Keys(), ver)
 
 	if err := dev.Reset(); err != nil {
-		return &dev, err
+		return dev, err
 	}
 
 	if *brightness > 100 {
 		*brightness = 100
 	}
 	if err = dev.SetBrightness(uint8(*brightness)); err != nil {
-		return &dev, err
+		return dev, err
 	}
 
 	dev.SetSleepFadeDuration(fadeDuration)
 	if len(*sleep) > 0 {
 		timeout, err := time.ParseDuration(*sleep)
 		if err != nil {
-			return &dev, err
+			return dev, err
 		}
 
 		dev.SetSleepTimeout(timeout)
 	}
+	if len(*idle) > 0 {
+		if idleTimeout, err = time.ParseDuration(*idle); err != nil {
+			return dev, err
+		}
+	}
 
-	return &dev, nil
+	return dev, nil
+}
+
+// runs a script of key presses on a virtual device and shuts down afterwards.
+func runVirtualScript(dev *VirtualDevice, path string) {
+	r := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fatal(err)
+			return
+		}
+		defer f.Close() //nolint:errcheck
+		r = f
+	}
+
+	if err := dev.RunScript(r); err != nil {
+		fatalf("error in virtual device script: %s", err)
+		return
+	}
+
+	// give the last actions a moment to finish
+	time.Sleep(longPressDuration)
+	shutdown <- nil
 }
 
 func run() error {
@@ -252,17 +370,32 @@ func run() error {
 	// initialize dbus connection
 	dbusConn, err = dbus.SessionBus()
 	if err != nil {
-		return fmt.Errorf("Unable to connect to dbus: %s", err)
+		if _, ok := dev.(*VirtualDevice); !ok {
+			return fmt.Errorf("Unable to connect to dbus: %s", err)
+		}
+
+		// a virtual device is often used in headless environments
+		fmt.Fprintf(os.Stderr, "Could not connect to dbus: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Triggering dbus calls will be disabled!")
 	}
 
-	// initialize xorg connection and track window focus
 	tch := make(chan interface{})
-	xorg, err = Connect(os.Getenv("DISPLAY"))
+
+	// blank the device while the session is locked
+	if err := watchSession(dbusConn, tch); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not watch login session: %s\n", err)
+	}
+
+	// pass dbus signals on to widgets and signal hooks
+	watchSignals(tch)
+
+	// connect to the window manager and track window focus
+	windowTracker, err = connectWindowTracker()
 	if err == nil {
-		defer xorg.Close()
-		xorg.TrackWindows(tch, time.Second)
+		defer windowTracker.Close()
+		windowTracker.TrackWindows(tch, time.Second)
 	} else {
-		fmt.Fprintf(os.Stderr, "Could not connect to X server: %s\n", err)
+		fmt.Fprintf(os.Stderr, "Could not connect to window manager: %s\n", err)
 		fmt.Fprintln(os.Stderr, "Tracking window manager will be disabled!")
 	}
 
@@ -280,9 +413,27 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("Can't load deck: %s", err)
 	}
+	startDeck = deck.File
 	deck.updateWidgets()
 
-	return eventLoop(dev, tch)
+	// listen for requests on the control socket
+	cch := make(chan controlCall)
+	if len(*socket) > 0 {
+		l, err := listenControl(*socket, cch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not listen on control socket: %s\n", err)
+			fmt.Fprintln(os.Stderr, "Remote control will be disabled!")
+		} else {
+			defer l.Close() //nolint:errcheck
+		}
+	}
+
+	// feed scripted key presses to a virtual device
+	if vdev, ok := dev.(*VirtualDevice); ok && len(*virtualScript) > 0 {
+		go runVirtualScript(vdev, *virtualScript)
+	}
+
+	return eventLoop(dev, tch, cch)
 }
 
 func main() {
@@ -305,6 +456,18 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validateCommand(flag.Args()[1:]))
+	case "ctl":
+		os.Exit(ctlCommand(flag.Args()[1:]))
+	}
+
+	if err := loadFonts(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading font:", err)
+		os.Exit(1)
+	}
+
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
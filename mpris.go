@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	mprisPrefix = "org.mpris.MediaPlayer2."
+	mprisPath   = "/org/mpris/MediaPlayer2"
+	mprisPlayer = "org.mpris.MediaPlayer2.Player"
+)
+
+// mediaPlayer is the state of an MPRIS media player.
+type mediaPlayer struct {
+	name   string // well-known name, e.g. org.mpris.MediaPlayer2.spotify
+	owner  string // unique name
+	status string // Playing, Paused or Stopped
+	title  string
+	artist string
+	artURL string
+
+	// the last time the player started playing
+	active time.Time
+}
+
+// mediaPlayers tracks the MPRIS players on the session bus.
+type mediaPlayers struct {
+	// only track players whose name starts with this, e.g. spotify
+	filter  string
+	players map[string]*mediaPlayer // by owner
+
+	// properties queried in the background, waiting to be applied
+	mutex   sync.Mutex
+	fetched []fetchedPlayer
+}
+
+// fetchedPlayer holds the properties of a player queried in the background.
+type fetchedPlayer struct {
+	name  string
+	owner string
+	props map[string]dbus.Variant
+	// the player was found by discover, rather than announced by a signal
+	discovered bool
+}
+
+func newMediaPlayers(filter string) *mediaPlayers {
+	return &mediaPlayers{
+		filter:  filter,
+		players: make(map[string]*mediaPlayer),
+	}
+}
+
+// discover finds the players currently on the bus. It blocks, so it should
+// run in the background. The players get added by applyFetched.
+func (mp *mediaPlayers) discover(conn *dbus.Conn) error {
+	var names []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !mp.tracks(name) {
+			continue
+		}
+
+		var owner string
+		if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner); err != nil {
+			continue
+		}
+		if err := mp.fetch(conn, name, owner, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't query media player %s: %s\n", name, err)
+		}
+	}
+
+	return nil
+}
+
+// fetch queries all properties of a player. It blocks, so it should run in
+// the background. The properties get applied by applyFetched.
+func (mp *mediaPlayers) fetch(conn *dbus.Conn, name, owner string, discovered bool) error {
+	var props map[string]dbus.Variant
+	err := conn.Object(owner, mprisPath).
+		Call("org.freedesktop.DBus.Properties.GetAll", 0, mprisPlayer).
+		Store(&props)
+	if err != nil {
+		return err
+	}
+
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.fetched = append(mp.fetched, fetchedPlayer{
+		name:       name,
+		owner:      owner,
+		props:      props,
+		discovered: discovered,
+	})
+	return nil
+}
+
+// applyFetched applies the properties queried in the background. It returns
+// true when anything changed.
+func (mp *mediaPlayers) applyFetched() bool {
+	mp.mutex.Lock()
+	fetched := mp.fetched
+	mp.fetched = nil
+	mp.mutex.Unlock()
+
+	for _, f := range fetched {
+		p, ok := mp.players[f.owner]
+		if !ok {
+			// players announced by a signal may have quit in the meantime
+			if !f.discovered {
+				continue
+			}
+			p = mp.add(f.name, f.owner)
+		}
+		p.update(f.props)
+	}
+
+	return len(fetched) > 0
+}
+
+// returns true if the player with the given name should be tracked.
+func (mp *mediaPlayers) tracks(name string) bool {
+	return strings.HasPrefix(name, mprisPrefix+mp.filter)
+}
+
+// adds a player.
+func (mp *mediaPlayers) add(name, owner string) *mediaPlayer {
+	p := &mediaPlayer{
+		name:  name,
+		owner: owner,
+	}
+	mp.players[owner] = p
+
+	return p
+}
+
+// current returns the most recently active player, preferring the ones that
+// are playing right now.
+func (mp *mediaPlayers) current() *mediaPlayer {
+	var current *mediaPlayer
+	for _, p := range mp.players {
+		if current == nil || p.preferred(current) {
+			current = p
+		}
+	}
+
+	return current
+}
+
+// handleSignal updates the players. It returns true when anything changed.
+func (mp *mediaPlayers) handleSignal(conn *dbus.Conn, sig *dbus.Signal) bool {
+	switch sig.Name {
+	case "org.freedesktop.DBus.NameOwnerChanged":
+		var name, oldOwner, newOwner string
+		if err := dbus.Store(sig.Body, &name, &oldOwner, &newOwner); err != nil || !mp.tracks(name) {
+			return false
+		}
+
+		delete(mp.players, oldOwner)
+		if newOwner != "" {
+			mp.add(name, newOwner)
+			if conn != nil {
+				go func() {
+					if err := mp.fetch(conn, name, newOwner, false); err != nil {
+						fmt.Fprintf(os.Stderr, "Can't query media player %s: %s\n", name, err)
+					}
+				}()
+			}
+		}
+		return true
+
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if sig.Path != mprisPath || dbus.Store(sig.Body, &iface, &props, &invalidated) != nil || iface != mprisPlayer {
+			return false
+		}
+
+		p, ok := mp.players[sig.Sender]
+		if !ok {
+			// the player was already on the bus, but we didn't know about it
+			if mp.filter != "" {
+				return false
+			}
+			p = mp.add("", sig.Sender)
+		}
+		p.update(props)
+		return true
+	}
+
+	return false
+}
+
+// preferred returns true if p should be shown rather than q.
+func (p *mediaPlayer) preferred(q *mediaPlayer) bool {
+	if (p.status == "Playing") != (q.status == "Playing") {
+		return p.status == "Playing"
+	}
+	if !p.active.Equal(q.active) {
+		return p.active.After(q.active)
+	}
+
+	// keep the result stable
+	return p.name+p.owner < q.name+q.owner
+}
+
+// update applies changed properties to a player.
+func (p *mediaPlayer) update(props map[string]dbus.Variant) {
+	if v, ok := props["PlaybackStatus"]; ok {
+		status, _ := v.Value().(string)
+		if status == "Playing" && p.status != "Playing" {
+			p.active = time.Now()
+		}
+		p.status = status
+	}
+
+	if v, ok := props["Metadata"]; ok {
+		metadata, _ := v.Value().(map[string]dbus.Variant)
+		p.title, p.artist, p.artURL = "", "", ""
+
+		if v, ok := lookupValue(metadata, []string{"xesam:title"}); ok {
+			p.title = formatValue("", v)
+		}
+		if v, ok := lookupValue(metadata, []string{"xesam:artist"}); ok {
+			p.artist = formatValue("", v)
+		}
+		if v, ok := lookupValue(metadata, []string{"mpris:artUrl"}); ok {
+			p.artURL, _ = v.(string)
+		}
+	}
+}
+
+// action returns an action calling a method of the player.
+func (p *mediaPlayer) action(method string) *ActionConfig {
+	return &ActionConfig{
+		DBus: DBusConfig{
+			Object: p.owner,
+			Path:   mprisPath,
+			Method: mprisPlayer + "." + method,
+		},
+	}
+}
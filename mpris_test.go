@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+// returns the NameOwnerChanged signal for a player.
+func ownerChanged(name, oldOwner, newOwner string) *dbus.Signal {
+	return &dbus.Signal{
+		Sender: "org.freedesktop.DBus",
+		Path:   "/org/freedesktop/DBus",
+		Name:   "org.freedesktop.DBus.NameOwnerChanged",
+		Body:   []interface{}{name, oldOwner, newOwner},
+	}
+}
+
+// returns the PropertiesChanged signal of a player.
+func playerChanged(owner, status, title string, artists ...string) *dbus.Signal {
+	props := map[string]dbus.Variant{}
+	if status != "" {
+		props["PlaybackStatus"] = dbus.MakeVariant(status)
+	}
+	if title != "" {
+		props["Metadata"] = dbus.MakeVariant(map[string]dbus.Variant{
+			"xesam:title":  dbus.MakeVariant(title),
+			"xesam:artist": dbus.MakeVariant(artists),
+		})
+	}
+
+	return &dbus.Signal{
+		Sender: owner,
+		Path:   mprisPath,
+		Name:   "org.freedesktop.DBus.Properties.PropertiesChanged",
+		Body:   []interface{}{mprisPlayer, props, []string{}},
+	}
+}
+
+func TestMediaPlayers(t *testing.T) {
+	mp := newMediaPlayers("")
+
+	steps := []struct {
+		name    string
+		signal  *dbus.Signal
+		changed bool
+		current string
+	}{
+		{"other names get ignored", ownerChanged("org.gnome.Shell", "", ":1.1"), false, ""},
+		{"spotify appears", ownerChanged(mprisPrefix+"spotify", "", ":1.10"), true, ":1.10"},
+		{"spotify plays", playerChanged(":1.10", "Playing", "Uprising", "Muse"), true, ":1.10"},
+		{"firefox appears", ownerChanged(mprisPrefix+"firefox", "", ":1.11"), true, ":1.10"},
+		{"other interfaces get ignored", &dbus.Signal{
+			Sender: ":1.11",
+			Path:   mprisPath,
+			Name:   "org.freedesktop.DBus.Properties.PropertiesChanged",
+			Body:   []interface{}{"org.mpris.MediaPlayer2", map[string]dbus.Variant{}, []string{}},
+		}, false, ":1.10"},
+		{"firefox plays", playerChanged(":1.11", "Playing", "Video"), true, ":1.11"},
+		{"firefox pauses", playerChanged(":1.11", "Paused", ""), true, ":1.10"},
+		{"spotify stops", playerChanged(":1.10", "Stopped", ""), true, ":1.11"},
+		{"firefox quits", ownerChanged(mprisPrefix+"firefox", ":1.11", ""), true, ":1.10"},
+		{"spotify quits", ownerChanged(mprisPrefix+"spotify", ":1.10", ""), true, ""},
+	}
+
+	for _, s := range steps {
+		if changed := mp.handleSignal(nil, s.signal); changed != s.changed {
+			t.Errorf("%s: expected changed to be %t", s.name, s.changed)
+		}
+
+		var current string
+		if p := mp.current(); p != nil {
+			current = p.owner
+		}
+		if current != s.current {
+			t.Errorf("%s: expected current player %q, got %q", s.name, s.current, current)
+		}
+	}
+}
+
+func TestMediaPlayersFilter(t *testing.T) {
+	mp := newMediaPlayers("spotify")
+
+	mp.handleSignal(nil, ownerChanged(mprisPrefix+"firefox", "", ":1.11"))
+	mp.handleSignal(nil, playerChanged(":1.12", "Playing", "Video"))
+	if p := mp.current(); p != nil {
+		t.Fatalf("expected no player, got %s", p.name)
+	}
+
+	mp.handleSignal(nil, ownerChanged(mprisPrefix+"spotify", "", ":1.10"))
+	mp.handleSignal(nil, playerChanged(":1.10", "Paused", "Uprising", "Muse"))
+	p := mp.current()
+	if p == nil || p.title != "Uprising" || p.artist != "Muse" || p.status != "Paused" {
+		t.Fatalf("expected paused spotify player, got %+v", p)
+	}
+
+	a := p.action("PlayPause")
+	if a.DBus.Object != ":1.10" || a.DBus.Method != mprisPlayer+".PlayPause" {
+		t.Errorf("expected PlayPause action for :1.10, got %+v", a.DBus)
+	}
+}
+
+func TestMediaPlayersFetched(t *testing.T) {
+	mp := newMediaPlayers("")
+	props := func(title string) map[string]dbus.Variant {
+		return map[string]dbus.Variant{
+			"PlaybackStatus": dbus.MakeVariant("Playing"),
+			"Metadata": dbus.MakeVariant(map[string]dbus.Variant{
+				"xesam:title": dbus.MakeVariant(title),
+			}),
+		}
+	}
+
+	if mp.applyFetched() {
+		t.Error("expected nothing to change without fetched players")
+	}
+
+	mp.handleSignal(nil, ownerChanged(mprisPrefix+"spotify", "", ":1.10"))
+	mp.handleSignal(nil, ownerChanged(mprisPrefix+"firefox", "", ":1.11"))
+	mp.handleSignal(nil, ownerChanged(mprisPrefix+"firefox", ":1.11", ""))
+	mp.fetched = []fetchedPlayer{
+		{name: mprisPrefix + "spotify", owner: ":1.10", props: props("Uprising")},
+		{name: mprisPrefix + "firefox", owner: ":1.11", props: props("Video")},
+		{name: mprisPrefix + "mpv", owner: ":1.12", props: props("Movie"), discovered: true},
+	}
+	if !mp.applyFetched() {
+		t.Error("expected fetched players to change")
+	}
+
+	if p := mp.players[":1.10"]; p == nil || p.title != "Uprising" {
+		t.Errorf("expected spotify to be updated, got %+v", p)
+	}
+	if p := mp.players[":1.11"]; p != nil {
+		t.Errorf("expected firefox to stay gone, got %+v", p)
+	}
+	if p := mp.players[":1.12"]; p == nil || p.name != mprisPrefix+"mpv" || p.title != "Movie" {
+		t.Errorf("expected discovered mpv to be added, got %+v", p)
+	}
+	if len(mp.fetched) != 0 {
+		t.Error("expected fetched players to be consumed")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+)
+
+const (
+	// backDeck is a deck target returning to the previous deck.
+	backDeck = "@back"
+	// homeDeck is a deck target returning to the initial deck.
+	homeDeck = "@home"
+
+	// maximum amount of decks remembered for navigating back.
+	maxDeckHistory = 64
+)
+
+var (
+	// the initial deck
+	startDeck string
+	// decks previously navigated away from, most recent last
+	deckHistory []string
+)
+
+// isDeckTarget returns true if deck is a special navigation target rather
+// than a deck file.
+func isDeckTarget(deck string) bool {
+	return deck == backDeck || deck == homeDeck
+}
+
+// navigateDeck switches to another deck, remembering the current one. The
+// special targets @back and @home return to the previous and initial deck.
+func navigateDeck(dev Device, base string, target string) error {
+	switch target {
+	case backDeck:
+		if len(deckHistory) == 0 {
+			return errors.New("no previous deck")
+		}
+
+		prev := deckHistory[len(deckHistory)-1]
+		if err := switchDeck(dev, "", prev); err != nil {
+			return err
+		}
+		deckHistory = deckHistory[:len(deckHistory)-1]
+		return nil
+
+	case homeDeck:
+		if err := switchDeck(dev, "", startDeck); err != nil {
+			return err
+		}
+		deckHistory = nil
+		return nil
+	}
+
+	current := deck.File
+	if err := switchDeck(dev, base, target); err != nil {
+		return err
+	}
+	if deck.File != current {
+		deckHistory = append(deckHistory, current)
+		if len(deckHistory) > maxDeckHistory {
+			deckHistory = deckHistory[len(deckHistory)-maxDeckHistory:]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNavigateDeck(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.deck", "media.deck", "player.deck"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(d *Deck, start string, history []string) {
+		deck, startDeck, deckHistory = d, start, history
+	}(deck, startDeck, deckHistory)
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	var err error
+	deck, err = LoadDeck(dev, dir, "main.deck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startDeck = deck.File
+	deckHistory = nil
+
+	steps := []struct {
+		target  string
+		want    string
+		history int
+		fail    bool
+	}{
+		{backDeck, "main.deck", 0, true},
+		{"media.deck", "media.deck", 1, false},
+		{"player.deck", "player.deck", 2, false},
+		{"player.deck", "player.deck", 2, false},
+		{"missing.deck", "player.deck", 2, true},
+		{backDeck, "media.deck", 1, false},
+		{"player.deck", "player.deck", 2, false},
+		{homeDeck, "main.deck", 0, false},
+	}
+
+	for _, s := range steps {
+		err := navigateDeck(dev, dir, s.target)
+		if (err != nil) != s.fail {
+			t.Fatalf("%s: unexpected error: %v", s.target, err)
+		}
+		if deck.File != filepath.Join(dir, s.want) {
+			t.Errorf("%s: expected deck %s, got %s", s.target, s.want, deck.File)
+		}
+		if len(deckHistory) != s.history {
+			t.Errorf("%s: expected %d decks in history, got %v", s.target, s.history, deckHistory)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// rule switches to a deck while a matching window is focused.
+type rule struct {
+	class *regexp.Regexp
+	name  *regexp.Regexp
+	deck  string
+}
+
+var (
+	// disables switching decks automatically
+	pinned bool
+
+	// the deck a rule switched to, the deck it replaced and the rule itself
+	autoDeck     string
+	autoPrevious string
+	autoRule     rule
+)
+
+// compileRules compiles the rules of a deck. Decks get resolved relative to
+// base.
+func compileRules(base string, rcs []RuleConfig) ([]rule, error) {
+	var rules []rule
+	for _, rc := range rcs {
+		r, err := compileRule(base, rc)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func compileRule(base string, rc RuleConfig) (rule, error) {
+	var r rule
+	var err error
+
+	if rc.Class == "" && rc.Name == "" {
+		return r, errors.New("rule needs a class or name to match")
+	}
+	if rc.Deck == "" {
+		return r, errors.New("rule needs a deck to switch to")
+	}
+
+	if rc.Class != "" {
+		if r.class, err = regexp.Compile(rc.Class); err != nil {
+			return r, fmt.Errorf("invalid class pattern: %w", err)
+		}
+	}
+	if rc.Name != "" {
+		if r.name, err = regexp.Compile(rc.Name); err != nil {
+			return r, fmt.Errorf("invalid name pattern: %w", err)
+		}
+	}
+	if r.deck, err = expandPath(base, rc.Deck); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// matches returns true if a window matches the rule.
+func (r rule) matches(w Window) bool {
+	return (r.class == nil || r.class.MatchString(w.Class)) &&
+		(r.name == nil || r.name.MatchString(w.Name))
+}
+
+// setPinned enables or disables switching decks automatically.
+func setPinned(pin bool) {
+	verbosef("Pinning current deck: %t", pin)
+	pinned = pin
+}
+
+// applyRules switches decks according to the rules of the current deck when
+// another window gets focused. A deck switched to by a rule gets replaced by
+// the previous deck again, once the rule no longer matches.
+func applyRules(dev Device, w Window) {
+	if pinned {
+		return
+	}
+
+	if autoDeck != "" {
+		switch {
+		case deck.File != autoDeck:
+			// the user navigated away, keep the current deck
+
+		case autoRule.matches(w):
+			return
+
+		default:
+			verbosef("Returning to deck %s", autoPrevious)
+			if err := switchDeck(dev, "", autoPrevious); err != nil {
+				fmt.Fprintln(os.Stderr, "Can't switch deck:", err)
+			}
+		}
+
+		autoDeck = ""
+	}
+
+	for _, r := range deck.rules {
+		if !r.matches(w) {
+			continue
+		}
+		if r.deck == deck.File {
+			return
+		}
+
+		prev := deck.File
+		verbosef("Window %s matches a rule, switching to deck %s", w.Class, r.deck)
+		if err := switchDeck(dev, "", r.deck); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't switch deck:", err)
+			return
+		}
+
+		autoDeck, autoPrevious, autoRule = deck.File, prev, r
+		return
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyRules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.deck": `[[rules]]
+  class = "^Gimp"
+  deck = "gimp.deck"
+
+[[rules]]
+  class = "^Code$"
+  name = "\\.go - "
+  deck = "go.deck"
+`,
+		"gimp.deck":  "",
+		"go.deck":    "",
+		"other.deck": "",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(d *Deck, history []string, p bool) {
+		deck, deckHistory, pinned, autoDeck = d, history, p, ""
+	}(deck, deckHistory, pinned)
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	var err error
+	deck, err = LoadDeck(dev, dir, "main.deck")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		action string // window class, or pin, unpin, or a deck to navigate to
+		name   string
+		want   string
+	}{
+		{"Firefox", "", "main.deck"},
+		{"Gimp-2.10", "", "gimp.deck"},
+		{"Gimp-2.10", "Export", "gimp.deck"},
+		{"Code", "main.go - deckmaster", "go.deck"},
+		{"Code", "README.md - deckmaster", "main.deck"},
+		{"pin", "", "main.deck"},
+		{"Gimp-2.10", "", "main.deck"},
+		{"unpin", "", "main.deck"},
+		{"Gimp-2.10", "", "gimp.deck"},
+		{"other.deck", "", "other.deck"},
+		{"Firefox", "", "other.deck"},
+	}
+
+	for _, s := range steps {
+		switch s.action {
+		case "pin":
+			setPinned(true)
+		case "unpin":
+			setPinned(false)
+		case "other.deck":
+			if err := navigateDeck(dev, dir, s.action); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			applyRules(dev, Window{Class: s.action, Name: s.name})
+		}
+
+		if deck.File != filepath.Join(dir, s.want) {
+			t.Errorf("%s %q: expected deck %s, got %s", s.action, s.name, s.want, deck.File)
+		}
+	}
+}
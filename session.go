@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus"
+)
+
+// SessionLockEvent gets emitted when the session gets locked or unlocked.
+type SessionLockEvent struct {
+	Locked bool
+}
+
+// ResumeEvent gets emitted after the system resumed from suspend.
+type ResumeEvent struct{}
+
+var (
+	// the session is locked
+	sessionLocked bool
+	// the deck shown before the session got locked
+	unlockedDeck string
+)
+
+// watchSession subscribes to the lock and suspend signals of logind on the
+// system bus, as well as the screensaver on the session bus, and emits
+// SessionLockEvents and ResumeEvents to ch.
+func watchSession(session *dbus.Conn, ch chan interface{}) error {
+	system, err := dbus.SystemBus()
+	if err != nil {
+		return err
+	}
+
+	rules := []string{
+		"type='signal',interface='org.freedesktop.login1.Manager',member='PrepareForSleep'",
+	}
+	if path, err := sessionPath(system); err == nil {
+		rules = append(rules, fmt.Sprintf("type='signal',interface='org.freedesktop.login1.Session',path='%s'", path))
+	} else {
+		verbosef("Can't find login session: %s", err)
+	}
+	if err := addMatches(system, rules); err != nil {
+		return err
+	}
+
+	sigs := make(chan *dbus.Signal, 16)
+	system.Signal(sigs)
+	if session != nil {
+		if err := addMatches(session, []string{
+			"type='signal',interface='org.freedesktop.ScreenSaver',member='ActiveChanged'",
+			"type='signal',interface='org.gnome.ScreenSaver',member='ActiveChanged'",
+		}); err != nil {
+			return err
+		}
+		session.Signal(sigs)
+	}
+
+	go func() {
+		for sig := range sigs {
+			if e, ok := sessionEvent(sig); ok {
+				ch <- e
+			}
+		}
+	}()
+
+	return nil
+}
+
+// returns the object path of the login session deckmaster runs in.
+func sessionPath(system *dbus.Conn) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	login := system.Object("org.freedesktop.login1", "/org/freedesktop/login1")
+
+	err := login.Call("org.freedesktop.login1.Manager.GetSessionByPID", 0, uint32(os.Getpid())).Store(&path)
+	if err != nil {
+		// services started by the user manager aren't part of the session
+		id := os.Getenv("XDG_SESSION_ID")
+		if id == "" {
+			return path, err
+		}
+		err = login.Call("org.freedesktop.login1.Manager.GetSession", 0, id).Store(&path)
+	}
+
+	return path, err
+}
+
+func addMatches(conn *dbus.Conn, rules []string) error {
+	for _, rule := range rules {
+		call := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule)
+		if call.Err != nil {
+			return call.Err
+		}
+	}
+
+	return nil
+}
+
+// converts a dbus signal to a session event.
+func sessionEvent(sig *dbus.Signal) (interface{}, bool) {
+	switch sig.Name {
+	case "org.freedesktop.login1.Session.Lock":
+		return SessionLockEvent{Locked: true}, true
+
+	case "org.freedesktop.login1.Session.Unlock":
+		return SessionLockEvent{Locked: false}, true
+
+	case "org.freedesktop.ScreenSaver.ActiveChanged", "org.gnome.ScreenSaver.ActiveChanged":
+		if len(sig.Body) > 0 {
+			if active, ok := sig.Body[0].(bool); ok {
+				return SessionLockEvent{Locked: active}, true
+			}
+		}
+
+	case "org.freedesktop.login1.Manager.PrepareForSleep":
+		// the signal gets sent with false after resuming
+		if len(sig.Body) > 0 {
+			if start, ok := sig.Body[0].(bool); ok && !start {
+				return ResumeEvent{}, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// handleLock blanks the device or shows the lock deck while the session is
+// locked.
+func handleLock(dev Device, locked bool) {
+	// logind and the screensaver often both report the same change
+	if locked == sessionLocked {
+		return
+	}
+	sessionLocked = locked
+	verbosef("Session locked: %t", locked)
+
+	if *lockDeck == "" {
+		var err error
+		if locked {
+			err = dev.Sleep()
+		} else {
+			err = dev.Wake()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't change device sleep state: %s\n", err)
+		}
+		return
+	}
+
+	var err error
+	if locked {
+		unlockedDeck = deck.File
+		err = switchDeck(dev, ".", *lockDeck)
+	} else {
+		err = switchDeck(dev, "", unlockedDeck)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't switch deck:", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestSessionEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		body []interface{}
+		want interface{}
+	}{
+		{"org.freedesktop.login1.Session.Lock", nil, SessionLockEvent{Locked: true}},
+		{"org.freedesktop.login1.Session.Unlock", nil, SessionLockEvent{Locked: false}},
+		{"org.gnome.ScreenSaver.ActiveChanged", []interface{}{true}, SessionLockEvent{Locked: true}},
+		{"org.freedesktop.ScreenSaver.ActiveChanged", []interface{}{false}, SessionLockEvent{Locked: false}},
+		{"org.freedesktop.login1.Manager.PrepareForSleep", []interface{}{false}, ResumeEvent{}},
+		{"org.freedesktop.login1.Manager.PrepareForSleep", []interface{}{true}, nil},
+		{"org.freedesktop.ScreenSaver.ActiveChanged", nil, nil},
+		{"org.freedesktop.Notifications.NotificationClosed", []interface{}{uint32(1)}, nil},
+	}
+
+	for _, tt := range tests {
+		e, ok := sessionEvent(&dbus.Signal{Name: tt.name, Body: tt.body})
+		if ok != (tt.want != nil) || !reflect.DeepEqual(e, tt.want) {
+			t.Errorf("%s %v: expected %#v, got %#v", tt.name, tt.body, tt.want, e)
+		}
+	}
+}
+
+func TestHandleLock(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.deck", "locked.deck"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(d *Deck, ld string) {
+		deck, *lockDeck, sessionLocked = d, ld, false
+	}(deck, *lockDeck)
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	var err error
+	deck, err = LoadDeck(dev, dir, "main.deck")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a lock deck, the device gets blanked
+	handleLock(dev, true)
+	if !dev.Asleep() {
+		t.Error("expected device to sleep while locked")
+	}
+	handleLock(dev, false)
+	if dev.Asleep() {
+		t.Error("expected device to wake up when unlocked")
+	}
+
+	*lockDeck = filepath.Join(dir, "locked.deck")
+	handleLock(dev, true)
+	handleLock(dev, true)
+	if deck.File != *lockDeck {
+		t.Errorf("expected lock deck, got %s", deck.File)
+	}
+	handleLock(dev, false)
+	if deck.File != filepath.Join(dir, "main.deck") {
+		t.Errorf("expected main deck after unlocking, got %s", deck.File)
+	}
+}
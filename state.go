@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// persistentState is the state deckmaster keeps across restarts.
+type persistentState struct {
+	Toggles map[string]bool `json:"toggles,omitempty"`
+}
+
+// guards reading and writing the state file.
+var stateMutex sync.Mutex
+
+// returns the path of the file deckmaster keeps its state in.
+func stateFile() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "deckmaster", "state.json"), nil
+}
+
+// reads the state file. A missing file results in an empty state.
+func readState() (persistentState, error) {
+	var s persistentState
+
+	path, err := stateFile()
+	if err != nil {
+		return s, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// loadToggleState returns the persisted state of a toggle. The second return
+// value is false if the state is unknown.
+func loadToggleState(name string) (bool, bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s, err := readState()
+	if err != nil {
+		verbosef("Can't read state: %s", err)
+		return false, false
+	}
+
+	on, ok := s.Toggles[name]
+	return on, ok
+}
+
+// saveToggleState persists the state of a toggle.
+func saveToggleState(name string, on bool) error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s, err := readState()
+	if err != nil {
+		return err
+	}
+	if s.Toggles == nil {
+		s.Toggles = make(map[string]bool)
+	}
+	s.Toggles[name] = on
+
+	path, err := stateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so the state never gets truncated
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// tomlIndex maps the keys of a TOML document to the lines they're defined in.
+// It only understands as much TOML as required to locate keys and tables.
+type tomlIndex struct {
+	lines   map[string]int // indexed path, e.g. keys.3.widget.id
+	entries []tomlEntry
+}
+
+// tomlEntry is a single key or table definition.
+type tomlEntry struct {
+	key  string // path without array indices, e.g. keys.widget.id
+	pos  int    // position within the top-level array, -1 if none
+	line int
+}
+
+// indexTOML builds a tomlIndex for a TOML document.
+func indexTOML(src string) *tomlIndex {
+	idx := &tomlIndex{
+		lines: make(map[string]int),
+	}
+
+	counts := make(map[string]int) // element count per array
+	latest := make(map[string]int) // latest element per array
+	var table []string
+
+	// resolves a key path to its indexed path, using the latest element of
+	// each array it passes.
+	resolve := func(parts []string) string {
+		var path string
+		for i, p := range parts {
+			if i > 0 {
+				path += "."
+			}
+			path += p
+			if n, ok := latest[path]; ok {
+				path += "." + strconv.Itoa(n)
+			}
+		}
+		return path
+	}
+	add := func(parts []string, line int) {
+		path := resolve(parts)
+		if _, ok := idx.lines[path]; !ok {
+			idx.lines[path] = line
+		}
+
+		pos := -1
+		if n, ok := latest[parts[0]]; ok && len(parts) > 1 {
+			pos = n
+		}
+		idx.entries = append(idx.entries, tomlEntry{
+			key:  strings.Join(parts, "."),
+			pos:  pos,
+			line: line,
+		})
+	}
+
+	var multiline string
+	var depth int
+	for i, l := range strings.Split(src, "\n") {
+		line := i + 1
+
+		// skip the remainder of multi-line strings and arrays
+		if multiline != "" {
+			if strings.Count(l, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		if depth > 0 {
+			depth += bracketDepth(l)
+			continue
+		}
+
+		l = strings.TrimSpace(stripComment(l))
+		switch {
+		case l == "":
+
+		case strings.HasPrefix(l, "[["):
+			parts := splitKey(strings.TrimSuffix(strings.TrimPrefix(l, "[["), "]]"))
+			parent := resolve(parts[:len(parts)-1])
+			array := parts[len(parts)-1]
+			if parent != "" {
+				array = parent + "." + array
+			}
+			latest[array] = counts[array]
+			counts[array]++
+
+			// forget the elements of nested arrays
+			for k := range latest {
+				if strings.HasPrefix(k, array+".") {
+					delete(latest, k)
+				}
+			}
+
+			table = parts
+			add(table, line)
+
+		case strings.HasPrefix(l, "["):
+			table = splitKey(strings.TrimSuffix(strings.TrimPrefix(l, "["), "]"))
+			add(table, line)
+
+		default:
+			eq := strings.Index(l, "=")
+			if eq < 0 {
+				continue
+			}
+
+			parts := append(append([]string{}, table...), splitKey(l[:eq])...)
+			add(parts, line)
+
+			value := l[eq+1:]
+			for _, q := range []string{`"""`, `'''`} {
+				if strings.Count(value, q)%2 == 1 {
+					multiline = q
+				}
+			}
+			depth = bracketDepth(value)
+		}
+	}
+
+	return idx
+}
+
+// line returns the line a key is defined in. If the key itself isn't defined,
+// it returns the line of its closest parent, or 0.
+func (idx *tomlIndex) line(path string) int {
+	for {
+		if line, ok := idx.lines[path]; ok {
+			return line
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return 0
+		}
+		path = path[:i]
+	}
+}
+
+// occurrences returns all definitions of a key path without array indices.
+func (idx *tomlIndex) occurrences(key string) []tomlEntry {
+	var entries []tomlEntry
+	for _, e := range idx.entries {
+		if e.key == key {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		entries = append(entries, tomlEntry{key: key, pos: -1})
+	}
+
+	return entries
+}
+
+// splits a dotted key into its parts, removing quotes.
+func splitKey(key string) []string {
+	parts := strings.Split(key, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+
+	return parts
+}
+
+// removes a trailing comment from a line.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// returns the amount of brackets left open in a value.
+func bracketDepth(value string) int {
+	var depth int
+	var quote rune
+	for _, r := range stripComment(value) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		}
+	}
+
+	return depth
+}
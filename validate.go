@@ -0,0 +1,578 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Problem describes an issue found while validating a deck.
+type Problem struct {
+	File    string
+	Line    int
+	Key     int // -1 if the problem isn't related to a key
+	Message string
+}
+
+func (p Problem) String() string {
+	var s strings.Builder
+	s.WriteString(p.File)
+	if p.Line > 0 {
+		fmt.Fprintf(&s, ":%d", p.Line)
+	}
+	if p.Key >= 0 {
+		fmt.Fprintf(&s, ": key %d", p.Key)
+	}
+	s.WriteString(": " + p.Message)
+
+	return s.String()
+}
+
+// tomlErrorLine matches decoding errors that carry a line number.
+var tomlErrorLine = regexp.MustCompile(`^toml: line (\d+) (.*)$`)
+
+// validator collects the problems of a tree of decks.
+type validator struct {
+	dev      Device
+	problems []Problem
+	decks    map[string]bool
+}
+
+// parsedDeck is a single, parsed deck file.
+type parsedDeck struct {
+	name   string
+	config DeckConfig
+	lines  *tomlIndex
+}
+
+// ValidateDeck validates a deck, its parents and all decks it references. It
+// returns all problems found.
+func ValidateDeck(dev Device, base string, deck string) []Problem {
+	v := validator{
+		dev:   dev,
+		decks: make(map[string]bool),
+	}
+	v.validateDeck(base, deck)
+
+	return v.problems
+}
+
+func (v *validator) report(file string, line int, key int, format string, a ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    file,
+		Line:    line,
+		Key:     key,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// validates a deck and its parents, much like LoadDeck loads them.
+func (v *validator) validateDeck(base, deck string) {
+	path, err := expandPath(base, deck)
+	if err != nil {
+		v.report(deck, 0, -1, "%s", err)
+		return
+	}
+	if v.decks[path] {
+		return
+	}
+	v.decks[path] = true
+
+	// everything gets resolved relative to the directory of the deck, not the
+	// directory of its parents
+	dir := filepath.Dir(path)
+
+	var refs []string
+	var chain []string
+	for name := filepath.Base(path); name != ""; {
+		filename, err := expandPath(dir, name)
+		if err != nil {
+			v.report(path, 0, -1, "%s", err)
+			break
+		}
+		if contains(chain, filename) {
+			v.report(chain[len(chain)-1], 0, -1, "circular reference: %s -> %s",
+				strings.Join(chain, " -> "), filename)
+			break
+		}
+		chain = append(chain, filename)
+
+		f, ok := v.parseFile(filename)
+		if !ok {
+			break
+		}
+		refs = append(refs, v.validateFile(dir, f)...)
+
+		name = f.config.Parent
+	}
+
+	for _, ref := range refs {
+		v.validateDeck(dir, ref)
+	}
+}
+
+// parses a deck file, reporting syntax errors and unknown settings.
+func (v *validator) parseFile(filename string) (*parsedDeck, bool) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		v.report(filename, 0, -1, "%s", err)
+		return nil, false
+	}
+
+	f := parsedDeck{
+		name:  filename,
+		lines: indexTOML(string(src)),
+	}
+	md, err := toml.Decode(string(src), &f.config)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			v.report(filename, perr.Position.Line, -1, "%s", perr.Message)
+		} else if m := tomlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			v.report(filename, line, -1, "%s", m[2])
+		} else {
+			v.report(filename, 0, -1, "%s", err)
+		}
+		return nil, false
+	}
+
+	var keys []string
+	undecoded := make(map[string]bool)
+	for _, k := range md.Undecoded() {
+		if !undecoded[k.String()] {
+			keys = append(keys, k.String())
+		}
+		undecoded[k.String()] = true
+	}
+	for _, k := range keys {
+		// only report the topmost unknown setting
+		if i := strings.LastIndex(k, "."); i > 0 && undecoded[k[:i]] {
+			continue
+		}
+
+		for _, e := range f.lines.occurrences(k) {
+			key := -1
+			if strings.HasPrefix(k, "keys.") {
+				key = f.keyIndex(e.pos)
+			}
+			v.report(filename, e.line, key, "unknown setting %s", k)
+		}
+	}
+
+	return &f, true
+}
+
+// validates the settings of a single deck file. It returns the decks
+// referenced by actions.
+func (v *validator) validateFile(dir string, f *parsedDeck) []string {
+	if f.config.Background != "" {
+		line := f.lines.line("background")
+		bgpath, err := expandPath(dir, f.config.Background)
+		if err == nil {
+			d := Deck{}
+			err = d.loadBackground(v.dev, bgpath)
+		}
+		if err != nil {
+			v.report(f.name, line, -1, "invalid background image: %s", err)
+		}
+	}
+
+	var refs []string
+	indices := make(map[uint8]bool)
+	for pos, k := range f.config.Keys {
+		path := "keys." + strconv.Itoa(pos)
+		key := int(k.Index)
+
+		if k.Index >= v.dev.Keys() {
+			v.report(f.name, f.lines.line(path+".index"), key,
+				"index out of range, the device has %d keys", v.dev.Keys())
+		}
+		if indices[k.Index] {
+			v.report(f.name, f.lines.line(path+".index"), key, "key is defined multiple times")
+		}
+		indices[k.Index] = true
+
+		refs = append(refs, v.validateWidget(dir, f, path+".widget", key, k.Widget)...)
+
+		refs = append(refs, v.validateAction(dir, f, path+".action", key, k.Action)...)
+		refs = append(refs, v.validateAction(dir, f, path+".action_hold", key, k.ActionHold)...)
+		refs = append(refs, v.validateAction(dir, f, path+".action_double", key, k.ActionDouble)...)
+		refs = append(refs, v.validateAction(dir, f, path+".action_release", key, k.ActionRelease)...)
+	}
+
+	for pos, rc := range f.config.Rules {
+		path := "rules." + strconv.Itoa(pos)
+		if _, err := compileRule(dir, rc); err != nil {
+			v.report(f.name, f.lines.line(path), -1, "invalid rule: %s", err)
+			continue
+		}
+
+		deck, err := expandPath(dir, rc.Deck)
+		if err == nil {
+			_, err = os.Stat(deck)
+		}
+		if err != nil {
+			v.report(f.name, f.lines.line(path+".deck"), -1, "invalid deck: %s", err)
+		} else {
+			refs = append(refs, rc.Deck)
+		}
+	}
+
+	for pos, c := range f.config.Chords {
+		path := "chords." + strconv.Itoa(pos)
+		line := f.lines.line(path + ".keys")
+
+		if len(c.Keys) < 2 {
+			v.report(f.name, line, -1, "chord needs at least two keys")
+		}
+		keys := make(map[uint8]bool)
+		for _, k := range c.Keys {
+			if k >= v.dev.Keys() {
+				v.report(f.name, line, -1, "chord key %d out of range, the device has %d keys", k, v.dev.Keys())
+			}
+			if keys[k] {
+				v.report(f.name, line, -1, "chord contains key %d multiple times", k)
+			}
+			keys[k] = true
+		}
+
+		if c.Action == nil {
+			v.report(f.name, f.lines.line(path), -1, "chord without action")
+		}
+		refs = append(refs, v.validateAction(dir, f, path+".action", -1, c.Action)...)
+	}
+
+	for pos, sc := range f.config.OnSignal {
+		path := "on_signal." + strconv.Itoa(pos)
+		if _, err := newSignalMatch(sc.Bus, sc.Sender, sc.Path, sc.Interface, sc.Member); err != nil {
+			v.report(f.name, f.lines.line(path), -1, "invalid signal: %s", err)
+		}
+
+		if sc.Action == nil {
+			v.report(f.name, f.lines.line(path), -1, "signal without action")
+		}
+		refs = append(refs, v.validateAction(dir, f, path+".action", -1, sc.Action)...)
+	}
+
+	return refs
+}
+
+// validates a widget's ID and config values. It returns the decks referenced
+// by actions in its config.
+func (v *validator) validateWidget(dir string, f *parsedDeck, path string, key int, wc WidgetConfig) []string {
+	schema, ok := widgetConfigs[wc.ID]
+	if !ok {
+		if wc.ID == "" {
+			v.report(f.name, f.lines.line(path), key, "missing widget ID")
+		} else {
+			v.report(f.name, f.lines.line(path+".id"), key, "unknown widget ID %s", wc.ID)
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(wc.Config))
+	for name := range wc.Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var refs []string
+	for _, name := range names {
+		line := f.lines.line(path + ".config." + name)
+
+		t, ok := schema[name]
+		if !ok {
+			v.report(f.name, line, key, "unknown config key %s for widget %s", name, wc.ID)
+			continue
+		}
+		if err := checkConfigValue(dir, t, wc.Config[name]); err != nil {
+			v.report(f.name, line, key, "invalid value for %s: %s", name, err)
+			continue
+		}
+
+		if t == configAction {
+			var a ActionConfig
+			_ = ConfigValue(wc.Config[name], &a)
+			refs = append(refs, v.validateAction(dir, f, path+".config."+name, key, &a)...)
+		}
+	}
+
+	if wc.ID == "top" {
+		v.validateTop(f, path+".config", key, wc)
+	}
+	if wc.ID == "command" || wc.ID == "stream" {
+		var gauges []string
+		_ = ConfigValue(wc.Config["gauge"], &gauges)
+		for _, g := range gauges {
+			if g == "" {
+				continue
+			}
+			if err := checkGaugeStyle(g); err != nil {
+				v.report(f.name, f.lines.line(path+".config.gauge"), key, "%s", err)
+			}
+		}
+	}
+	if _, ok := schema["graph"]; ok {
+		var style string
+		_ = ConfigValue(wc.Config["graph"], &style)
+		if style != "" {
+			if err := checkGraphStyle(style); err != nil {
+				v.report(f.name, f.lines.line(path+".config.graph"), key, "%s", err)
+			}
+		}
+	}
+
+	return refs
+}
+
+// validates the mode of a top widget.
+func (v *validator) validateTop(f *parsedDeck, path string, key int, wc WidgetConfig) {
+	var mode, direction string
+	_ = ConfigValue(wc.Config["mode"], &mode)
+	_ = ConfigValue(wc.Config["direction"], &direction)
+	if mode == "" {
+		mode = "cpu"
+	}
+
+	if err := checkTopMode(mode, ""); err != nil {
+		v.report(f.name, f.lines.line(path+".mode"), key, "%s", err)
+	} else if err := checkTopMode(mode, direction); err != nil {
+		v.report(f.name, f.lines.line(path+".direction"), key, "%s", err)
+	}
+}
+
+// validates an action. It returns the decks referenced by it.
+func (v *validator) validateAction(dir string, f *parsedDeck, path string, key int, a *ActionConfig) []string {
+	if a == nil {
+		return nil
+	}
+
+	var refs []string
+	if a.Deck != "" && !isDeckTarget(a.Deck) {
+		deck, err := expandPath(dir, a.Deck)
+		if err == nil {
+			_, err = os.Stat(deck)
+		}
+		if err != nil {
+			v.report(f.name, f.lines.line(path+".deck"), key, "invalid deck: %s", err)
+		} else {
+			refs = append(refs, a.Deck)
+		}
+	}
+	if a.Keycode != "" {
+		if _, err := parseKeyPresses(a.Keycode); err != nil {
+			v.report(f.name, f.lines.line(path+".keycode"), key, "invalid keycode: %s", err)
+		}
+	}
+	if a.Device != "" {
+		switch {
+		case a.Device == "sleep":
+		case strings.HasPrefix(a.Device, "brightness"):
+			if _, err := parseBrightness(strings.TrimPrefix(a.Device, "brightness"), 50); err != nil {
+				v.report(f.name, f.lines.line(path+".device"), key, "%s", err)
+			}
+		case a.Device == "pin":
+		default:
+			v.report(f.name, f.lines.line(path+".device"), key, "unrecognized device action %s", a.Device)
+		}
+	}
+	if !reflect.DeepEqual(a.DBus, DBusConfig{}) {
+		v.validateDBus(f, path+".dbus", key, a.DBus)
+	}
+	if a.Volume != nil {
+		v.validateVolume(f, path+".volume", key, *a.Volume)
+	}
+	if a.Wait != "" {
+		if _, err := time.ParseDuration(a.Wait); err != nil {
+			v.report(f.name, f.lines.line(path+".wait"), key, "invalid wait duration: %s", err)
+		}
+	}
+	if a.Repeat < 0 {
+		v.report(f.name, f.lines.line(path+".repeat"), key, "repeat can't be negative")
+	}
+
+	for i := range a.Steps {
+		step := path + ".steps." + strconv.Itoa(i)
+		if len(a.Steps[i].Steps) > 0 {
+			v.report(f.name, f.lines.line(step+".steps"), key, "steps can't be nested")
+			continue
+		}
+		refs = append(refs, v.validateAction(dir, f, step, key, &a.Steps[i])...)
+	}
+
+	return refs
+}
+
+// validates the settings of a dbus action.
+func (v *validator) validateDBus(f *parsedDeck, path string, key int, c DBusConfig) {
+	line := f.lines.line(path)
+
+	switch {
+	case c.Method == "" && c.Property == "":
+		v.report(f.name, line, key, "dbus action without method or property")
+	case c.Method != "" && c.Property != "":
+		v.report(f.name, line, key, "dbus action can't have both a method and a property")
+	}
+	if c.Bus != "" && c.Bus != "session" && c.Bus != "system" {
+		v.report(f.name, f.lines.line(path+".bus"), key, "unknown bus %s", c.Bus)
+	}
+	if _, err := dbusArgs(c); err != nil {
+		v.report(f.name, line, key, "invalid dbus arguments: %s", err)
+	}
+}
+
+// validates the settings of a volume action.
+func (v *validator) validateVolume(f *parsedDeck, path string, key int, c VolumeConfig) {
+	if _, err := newAudioDevice(c.Device, c.Name); err != nil {
+		v.report(f.name, f.lines.line(path+".device"), key, "%s", err)
+	}
+	if c.Adjust == "" && c.Mute == "" {
+		v.report(f.name, f.lines.line(path), key, "volume action without adjust or mute")
+	}
+	if c.Adjust != "" {
+		if _, err := parseVolumeAdjustment(c.Adjust, 0); err != nil {
+			v.report(f.name, f.lines.line(path+".adjust"), key, "%s", err)
+		}
+	}
+	if c.Mute != "" {
+		if _, err := muteArg(c.Mute); err != nil {
+			v.report(f.name, f.lines.line(path+".mute"), key, "%s", err)
+		}
+	}
+}
+
+// checks whether a config value can be converted to the expected type.
+func checkConfigValue(dir string, t configType, value interface{}) error {
+	switch t {
+	case configString:
+		var s string
+		return ConfigValue(value, &s)
+
+	case configStrings:
+		var s []string
+		return ConfigValue(value, &s)
+
+	case configBool:
+		var b bool
+		return ConfigValue(value, &b)
+
+	case configInt:
+		var i int64
+		return ConfigValue(value, &i)
+
+	case configFloat:
+		var f float64
+		return ConfigValue(value, &f)
+
+	case configColor:
+		var c color.Color
+		return ConfigValue(value, &c)
+
+	case configColors:
+		var c []color.Color
+		return ConfigValue(value, &c)
+
+	case configIcon:
+		var icon string
+		if err := ConfigValue(value, &icon); err != nil {
+			return err
+		}
+		path, err := expandPath(dir, icon)
+		if err != nil {
+			return err
+		}
+		_, err = loadImage(path)
+		return err
+
+	case configAction:
+		var a ActionConfig
+		return ConfigValue(value, &a)
+
+	case configLayout:
+		var frames []string
+		if err := ConfigValue(value, &frames); err != nil {
+			return err
+		}
+		for _, frame := range frames {
+			if _, err := formatFrame(frame); err != nil {
+				return fmt.Errorf("%s: %s", frame, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns the key index of the n-th key in a deck file.
+func (f *parsedDeck) keyIndex(n int) int {
+	if n < 0 || n >= len(f.config.Keys) {
+		return -1
+	}
+
+	return int(f.config.Keys[n].Index)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateCommand implements the validate sub-command. It returns the exit
+// code.
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: deckmaster validate [flags] [deck files]")
+		flags.PrintDefaults()
+	}
+	modelName := *virtual
+	if modelName == "" {
+		modelName = "original"
+	}
+	model := flags.String("model", modelName, "device to validate against (mini, original, xl or [columns]x[rows]@[pixels])")
+	_ = flags.Parse(args)
+
+	m, err := ParseVirtualModel(*model)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	dev := NewVirtualDevice(m, "")
+
+	decks := flags.Args()
+	if len(decks) == 0 {
+		decks = []string{*deckFile}
+	}
+
+	var problems []Problem
+	for _, deck := range decks {
+		problems = append(problems, ValidateDeck(dev, ".", deck)...)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d problem(s)\n", len(problems))
+		return 1
+	}
+
+	return 0
+}
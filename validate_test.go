@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDeck(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.deck": `parent = "parent.deck"
+
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "buton"
+
+[[keys]]
+  index = 1
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      lable = "typo" # unknown key
+      color = "#zzz"
+  [keys.action]
+    keycode = "Leftctrl-Foo"
+    deck = "sub.deck"
+
+[[keys]]
+  index = 15
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      icon = "missing.png"
+  [keys.action_hold]
+    device = "explode"
+
+[[keys]]
+  index = 3
+  [keys.widget]
+    id = "button"
+  [[keys.action.steps]]
+    keycode = "Foo"
+  [[keys.action.steps]]
+    wait = "soon"
+    repeat = -1
+
+[[keys]]
+  index = 4
+  [keys.widget]
+    id = "button"
+  [keys.action.dbus]
+    bus = "user"
+    signature = "x"
+    args = ["soon"]
+
+[[keys]]
+  index = 5
+  [keys.widget]
+    id = "top"
+    [keys.widget.config]
+      mode = "gpu"
+
+[[keys]]
+  index = 6
+  [keys.widget]
+    id = "top"
+    [keys.widget.config]
+      mode = "network"
+      direction = "up"
+      graph = "bars"
+
+[[keys]]
+  index = 7
+  [keys.widget]
+    id = "command"
+    [keys.widget.config]
+      command = "echo 1"
+      gauge = "donut"
+`,
+		"parent.deck": `[[chords]]
+  keys = [2, 2, 20]
+  [chords.action]
+    keycode = "Foo"
+
+[[keys]]
+  index = 2
+  [keys.widget]
+    id = "top"
+    interval = 500
+    colour = "#fff"
+
+[[on_signal]]
+  bus = "user"
+  member = "Changed"
+`,
+		"sub.deck": `[[keys]]
+  index = "zero"
+`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	main := filepath.Join(dir, "main.deck")
+	parent := filepath.Join(dir, "parent.deck")
+	sub := filepath.Join(dir, "sub.deck")
+	want := []Problem{
+		{main, 6, 0, "unknown widget ID buton"},
+		{main, 14, 1, "invalid value for color: invalid color \"#zzz\""},
+		{main, 13, 1, "unknown config key lable for widget button"},
+		{main, 16, 1, "invalid keycode: Foo is not a valid keycode"},
+		{main, 20, 15, "index out of range, the device has 15 keys"},
+		{main, 24, 15, "invalid value for icon: open " + filepath.Join(dir, "missing.png") + ": no such file or directory"},
+		{main, 26, 15, "unrecognized device action explode"},
+		{main, 33, 3, "invalid keycode: Foo is not a valid keycode"},
+		{main, 35, 3, "invalid wait duration: time: invalid duration \"soon\""},
+		{main, 36, 3, "repeat can't be negative"},
+		{main, 42, 4, "dbus action without method or property"},
+		{main, 43, 4, "unknown bus user"},
+		{main, 42, 4, "invalid dbus arguments: argument 1: can't convert \"soon\" to x"},
+		{main, 52, 5, "unknown mode gpu, expected one of cpu, core, memory, swap, disk, diskio, network, load, temperature"},
+		{main, 60, 6, "unknown direction up, expected rx or tx"},
+		{main, 61, 6, "unknown graph style bars, expected line or area"},
+		{main, 69, 7, "unknown gauge style donut, expected hbar, vbar, arc or pie"},
+		{parent, 11, 2, "unknown setting keys.widget.colour"},
+		{parent, 2, -1, "chord contains key 2 multiple times"},
+		{parent, 2, -1, "chord key 20 out of range, the device has 15 keys"},
+		{parent, 4, -1, "invalid keycode: Foo is not a valid keycode"},
+		{parent, 13, -1, "invalid signal: unknown bus user"},
+		{parent, 13, -1, "signal without action"},
+		{sub, 2, -1, "(last key \"keys.index\"): incompatible types: TOML value has type string; destination has type integer"},
+	}
+
+	dev := NewVirtualDevice(virtualModels["original"], "")
+	got := ValidateDeck(dev, ".", main)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d problems, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected problem %q, got %q", want[i], got[i])
+		}
+	}
+}
+
+func TestIndexTOML(t *testing.T) {
+	src := `background = "bg.png" # comment
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "command"
+    [keys.widget.config]
+      command = """
+        echo 'not = a key'
+      """
+      "font" = "bold"
+
+[[keys]]
+  index = 1
+  [keys.action]
+    exec = [
+      "x = 1",
+    ]
+    deck = "other.deck"
+`
+	idx := indexTOML(src)
+
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"background", 1},
+		{"keys.0", 2},
+		{"keys.0.index", 3},
+		{"keys.0.widget.config.command", 7},
+		{"keys.0.widget.config.font", 10},
+		{"keys.1.index", 13},
+		{"keys.1.action.deck", 18},
+		{"keys.1.action.exec", 15},
+		{"keys.1.widget.id", 12},
+		{"nothing", 0},
+	}
+	for _, tt := range tests {
+		if line := idx.line(tt.path); line != tt.line {
+			t.Errorf("%s: expected line %d, got %d", tt.path, tt.line, line)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// FileWatcher detects changes to a set of files by polling them.
+type FileWatcher struct {
+	files map[string]fileState
+}
+
+// fileState is the last known state of a watched file.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// NewFileWatcher returns a new FileWatcher for files.
+func NewFileWatcher(files []string) *FileWatcher {
+	w := &FileWatcher{
+		files: make(map[string]fileState, len(files)),
+	}
+	for _, f := range files {
+		w.files[f] = statFile(f)
+	}
+
+	return w
+}
+
+// Changed returns true when any of the files changed since the last call.
+func (w *FileWatcher) Changed() bool {
+	var changed bool
+	for f, state := range w.files {
+		if s := statFile(f); s != state {
+			verbosef("File changed: %s", f)
+			w.files[f] = s
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{
+		exists:  true,
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.deck")
+	missing := filepath.Join(dir, "missing.png")
+	if err := ioutil.WriteFile(file, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewFileWatcher([]string{file, missing})
+	if w.Changed() {
+		t.Fatal("expected no changes")
+	}
+
+	// modified
+	if err := ioutil.WriteFile(file, []byte("ab"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("expected modified file to be detected")
+	}
+	if w.Changed() {
+		t.Error("expected change to be reported only once")
+	}
+
+	// touched
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("expected touched file to be detected")
+	}
+
+	// created
+	if err := ioutil.WriteFile(missing, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("expected created file to be detected")
+	}
+
+	// removed
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("expected removed file to be detected")
+	}
+}
@@ -11,13 +11,14 @@ import (
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
-	"github.com/muesli/streamdeck"
 	"github.com/nfnt/resize"
 )
 
 var (
 	// DefaultColor is the standard color for text rendering.
 	DefaultColor = color.RGBA{255, 255, 255, 255}
+	// DefaultFillColor is the standard color for filling bars.
+	DefaultFillColor = color.RGBA{166, 155, 182, 255}
 )
 
 // Widget is an interface implemented by all available widgets.
@@ -30,13 +31,20 @@ type Widget interface {
 	TriggerAction(hold bool)
 }
 
+// closer is implemented by widgets holding resources, like processes, which
+// need to be released once the widget gets replaced.
+type closer interface {
+	Close()
+}
+
 // BaseWidget provides common functionality required by all widgets.
 type BaseWidget struct {
-	base       string
+	base       string // directory of the deck file
+	file       string // path of the deck file
 	key        uint8
 	action     *ActionConfig
 	actionHold *ActionConfig
-	dev        *streamdeck.Device
+	dev        Device
 	background image.Image
 	lastUpdate time.Time
 	interval   time.Duration
@@ -79,7 +87,7 @@ func (w *BaseWidget) Update() error {
 }
 
 // NewBaseWidget returns a new BaseWidget.
-func NewBaseWidget(dev *streamdeck.Device, base string, index uint8, action, actionHold *ActionConfig, bg image.Image) *BaseWidget {
+func NewBaseWidget(dev Device, base string, index uint8, action, actionHold *ActionConfig, bg image.Image) *BaseWidget {
 	return &BaseWidget{
 		base:       base,
 		key:        index,
@@ -90,9 +98,10 @@ func NewBaseWidget(dev *streamdeck.Device, base string, index uint8, action, act
 	}
 }
 
-// NewWidget initializes a widget.
-func NewWidget(dev *streamdeck.Device, base string, kc KeyConfig, bg image.Image) (Widget, error) {
-	bw := NewBaseWidget(dev, base, kc.Index, kc.Action, kc.ActionHold, bg)
+// NewWidget initializes a widget of the deck in file.
+func NewWidget(dev Device, file string, kc KeyConfig, bg image.Image) (Widget, error) {
+	bw := NewBaseWidget(dev, filepath.Dir(file), kc.Index, kc.Action, kc.ActionHold, bg)
+	bw.file = file
 
 	switch kc.Widget.ID {
 	case "button":
@@ -117,24 +126,172 @@ func NewWidget(dev *streamdeck.Device, base string, kc KeyConfig, bg image.Image
 		return NewRecentWindowWidget(bw, kc.Widget)
 
 	case "top":
-		return NewTopWidget(bw, kc.Widget), nil
+		return NewTopWidget(bw, kc.Widget)
 
 	case "command":
-		return NewCommandWidget(bw, kc.Widget), nil
+		return NewCommandWidget(bw, kc.Widget)
 
 	case "weather":
 		return NewWeatherWidget(bw, kc.Widget)
+
+	case "toggle":
+		return NewToggleWidget(bw, kc.Widget)
+
+	case "dbus":
+		return NewDBusWidget(bw, kc.Widget)
+
+	case "media":
+		return NewMediaWidget(bw, kc.Widget)
+
+	case "volume":
+		return NewVolumeWidget(bw, kc.Widget)
+
+	case "battery":
+		return NewBatteryWidget(bw, kc.Widget)
+
+	case "stream":
+		return NewStreamWidget(bw, kc.Widget)
 	}
 
 	// unknown widget ID
 	return nil, fmt.Errorf("Unknown widget with ID %s", kc.Widget.ID)
 }
 
+// configType describes the expected type of a widget config value.
+type configType int
+
+const (
+	configString configType = iota
+	configStrings
+	configBool
+	configInt
+	configFloat
+	configColor
+	configColors
+	configIcon
+	configLayout
+	configAction
+)
+
+// buttonConfig lists the config values understood by ButtonWidget.
+var buttonConfig = map[string]configType{
+	"icon":     configIcon,
+	"label":    configString,
+	"fontsize": configFloat,
+	"color":    configColor,
+	"flatten":  configBool,
+}
+
+// commandConfig lists the config values understood by CommandWidget.
+var commandConfig = extendConfig(graphConfig, map[string]configType{
+	"command":    configStrings,
+	"font":       configStrings,
+	"color":      configColors,
+	"layout":     configLayout,
+	"gauge":      configStrings,
+	"json":       configBool,
+	"alertColor": configColor,
+})
+
+// widgetConfigs lists the config values understood by each widget. It needs to
+// be kept in sync with the widgets created by NewWidget.
+var widgetConfigs = map[string]map[string]configType{
+	"button": buttonConfig,
+	"clock":  {},
+	"date":   {},
+	"time": {
+		"format": configStrings,
+		"font":   configStrings,
+		"color":  configColors,
+		"layout": configLayout,
+	},
+	"recentWindow": extendConfig(buttonConfig, map[string]configType{
+		"window":    configInt,
+		"showTitle": configBool,
+	}),
+	"top": extendConfig(graphConfig, map[string]configType{
+		"mode":      configString,
+		"label":     configString,
+		"max":       configFloat,
+		"mount":     configString,
+		"device":    configString,
+		"interface": configString,
+		"direction": configString,
+		"core":      configInt,
+		"sensor":    configString,
+		"color":     configColor,
+		"fillColor": configColor,
+	}),
+	"command": commandConfig,
+	"stream":  commandConfig,
+	"weather": extendConfig(buttonConfig, map[string]configType{
+		"location": configString,
+		"unit":     configString,
+		"theme":    configString,
+	}),
+	"toggle": extendConfig(buttonConfig, map[string]configType{
+		"iconOn":    configIcon,
+		"labelOn":   configString,
+		"colorOn":   configColor,
+		"actionOn":  configAction,
+		"actionOff": configAction,
+		"state":     configString,
+		"name":      configString,
+	}),
+	"dbus": extendConfig(buttonConfig, map[string]configType{
+		"bus":       configString,
+		"sender":    configString,
+		"path":      configString,
+		"interface": configString,
+		"member":    configString,
+		"property":  configString,
+		"arg":       configInt,
+		"key":       configString,
+		"format":    configString,
+	}),
+	"media": {
+		"player": configString,
+		"color":  configColor,
+	},
+	"volume": {
+		"device":     configString,
+		"name":       configString,
+		"label":      configString,
+		"color":      configColor,
+		"fillColor":  configColor,
+		"mutedColor": configColor,
+	},
+	"battery": {
+		"device":        configString,
+		"label":         configString,
+		"warn":          configFloat,
+		"critical":      configFloat,
+		"color":         configColor,
+		"fillColor":     configColor,
+		"chargingColor": configColor,
+		"warnColor":     configColor,
+		"criticalColor": configColor,
+	},
+}
+
+// returns a copy of base, extended by the values in ext.
+func extendConfig(base, ext map[string]configType) map[string]configType {
+	m := make(map[string]configType, len(base)+len(ext))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range ext {
+		m[k] = v
+	}
+
+	return m
+}
+
 // renders the widget including its background image.
-func (w *BaseWidget) render(dev *streamdeck.Device, fg image.Image) error {
+func (w *BaseWidget) render(dev Device, fg image.Image) error {
 	w.lastUpdate = time.Now()
 
-	pixels := int(dev.Pixels)
+	pixels := int(dev.Pixels())
 	img := image.NewRGBA(image.Rect(0, 0, pixels, pixels))
 	if w.background != nil {
 		draw.Draw(img, img.Bounds(), w.background, image.Point{}, draw.Over)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BatteryWidget is a widget displaying the charge of a battery as a bar.
+type BatteryWidget struct {
+	*BaseWidget
+
+	device        string
+	label         string
+	warn          float64
+	critical      float64
+	color         color.Color
+	fillColor     color.Color
+	chargingColor color.Color
+	warnColor     color.Color
+	criticalColor color.Color
+
+	// polls UPower for batteries the kernel doesn't know about
+	upower *upowerWatcher
+	// the last error, so it only gets reported once
+	lastErr string
+}
+
+// NewBatteryWidget returns a new BatteryWidget.
+func NewBatteryWidget(bw *BaseWidget, opts WidgetConfig) (*BatteryWidget, error) {
+	bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, 5*time.Second)
+
+	var device, label string
+	_ = ConfigValue(opts.Config["device"], &device)
+	_ = ConfigValue(opts.Config["label"], &label)
+	warn, critical := 30.0, 10.0
+	_ = ConfigValue(opts.Config["warn"], &warn)
+	_ = ConfigValue(opts.Config["critical"], &critical)
+	var clr, fillColor, chargingColor, warnColor, criticalColor color.Color
+	_ = ConfigValue(opts.Config["color"], &clr)
+	_ = ConfigValue(opts.Config["fillColor"], &fillColor)
+	_ = ConfigValue(opts.Config["chargingColor"], &chargingColor)
+	_ = ConfigValue(opts.Config["warnColor"], &warnColor)
+	_ = ConfigValue(opts.Config["criticalColor"], &criticalColor)
+
+	if label == "" {
+		label = "BAT"
+	}
+	if clr == nil {
+		clr = DefaultColor
+	}
+	if fillColor == nil {
+		fillColor = DefaultFillColor
+	}
+	if chargingColor == nil {
+		chargingColor = color.RGBA{90, 180, 90, 255}
+	}
+	if warnColor == nil {
+		warnColor = color.RGBA{230, 160, 40, 255}
+	}
+	if criticalColor == nil {
+		criticalColor = color.RGBA{200, 60, 60, 255}
+	}
+
+	return &BatteryWidget{
+		BaseWidget:    bw,
+		device:        device,
+		label:         label,
+		warn:          warn,
+		critical:      critical,
+		color:         clr,
+		fillColor:     fillColor,
+		chargingColor: chargingColor,
+		warnColor:     warnColor,
+		criticalColor: criticalColor,
+	}, nil
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *BatteryWidget) RequiresUpdate() bool {
+	return (w.upower != nil && w.upower.updated()) || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *BatteryWidget) Update() error {
+	size := int(w.dev.Pixels())
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	b, err := w.battery()
+	if err != nil {
+		if err != errUPowerPending && err.Error() != w.lastErr {
+			fmt.Fprintf(os.Stderr, "Can't read battery: %s\n", err)
+			w.lastErr = err.Error()
+		}
+		drawBar(img, w.dev.DPI(), 0, "?", w.label, w.color, w.fillColor)
+
+		return w.render(w.dev, img)
+	}
+	w.lastErr = ""
+
+	drawBar(img, w.dev.DPI(), b.percent, strconv.Itoa(int(b.percent)), w.batteryLabel(b), w.color, w.barColor(b))
+	return w.render(w.dev, img)
+}
+
+// Close stops polling UPower.
+func (w *BatteryWidget) Close() {
+	if w.upower != nil {
+		w.upower.close()
+	}
+}
+
+// returns the state of the battery. Querying UPower blocks, so batteries the
+// kernel doesn't know about get polled in the background.
+func (w *BatteryWidget) battery() (battery, error) {
+	if b, ok := findSysfsBattery(w.device); ok {
+		return b, nil
+	}
+
+	if w.upower == nil {
+		w.upower = newUPowerWatcher(w.device, w.interval)
+	}
+	return w.upower.state()
+}
+
+// returns the color of the bar, depending on the battery's charge.
+func (w *BatteryWidget) barColor(b battery) color.Color {
+	switch {
+	case b.charging():
+		return w.chargingColor
+	case b.percent <= w.critical:
+		return w.criticalColor
+	case b.percent <= w.warn:
+		return w.warnColor
+	}
+
+	return w.fillColor
+}
+
+// returns the label shown underneath the bar, e.g. "BAT 1:23" while
+// discharging with an hour and 23 minutes left.
+func (w *BatteryWidget) batteryLabel(b battery) string {
+	label := w.label
+	switch b.status {
+	case "Charging":
+		label = "CHG"
+	case "Full":
+		return "FULL"
+	}
+
+	if b.timeLeft <= 0 {
+		return "% " + label
+	}
+
+	minutes := int(b.timeLeft.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%s %d:%02d", label, minutes/60, minutes%60)
+}
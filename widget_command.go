@@ -1,35 +1,78 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
+	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// matches the first number in a command's output.
+var commandNumber = regexp.MustCompile(`[-+]?(\d+\.?\d*|\.\d+)`)
+
 // CommandWidget is a widget displaying the output of command(s).
 type CommandWidget struct {
 	*BaseWidget
 
-	commands []string
-	fonts    []string
-	frames   []image.Rectangle
-	colors   []color.Color
+	commands   []string
+	fonts      []string
+	frameReps  []string
+	frames     []image.Rectangle
+	colors     []color.Color
+	gauges     []string
+	graph      *graph
+	json       bool
+	alertColor color.Color
+
+	// whether the lack of a number in a command's output has been reported
+	reported []bool
+	// the last error parsing JSON output, so it only gets reported once
+	lastErr string
+
+	// the last icon shown in JSON mode
+	iconPath string
+	icon     image.Image
+}
+
+// commandOutput is the appearance of a key, as printed by a command in JSON
+// mode.
+type commandOutput struct {
+	Label      string   `json:"label"`
+	Icon       string   `json:"icon"`
+	Color      string   `json:"color"`
+	Background string   `json:"background"`
+	Progress   *float64 `json:"progress"`
+	Alert      bool     `json:"alert"`
 }
 
 // NewCommandWidget returns a new CommandWidget.
-func NewCommandWidget(bw *BaseWidget, opts WidgetConfig) *CommandWidget {
+func NewCommandWidget(bw *BaseWidget, opts WidgetConfig) (*CommandWidget, error) {
 	bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, time.Second)
 
-	var commands, fonts, frameReps []string
+	var commands, fonts, frameReps, gauges []string
 	_ = ConfigValue(opts.Config["command"], &commands)
 	_ = ConfigValue(opts.Config["font"], &fonts)
 	_ = ConfigValue(opts.Config["layout"], &frameReps)
+	_ = ConfigValue(opts.Config["gauge"], &gauges)
 	var colors []color.Color
 	_ = ConfigValue(opts.Config["color"], &colors)
+	var jsonOutput bool
+	_ = ConfigValue(opts.Config["json"], &jsonOutput)
+	var alertColor color.Color
+	_ = ConfigValue(opts.Config["alertColor"], &alertColor)
+	if alertColor == nil {
+		alertColor = color.RGBA{200, 60, 60, 255}
+	}
 
-	layout := NewLayout(int(bw.dev.Pixels))
+	layout := NewLayout(int(bw.dev.Pixels()))
 	frames := layout.FormatLayout(frameReps, len(commands))
 
 	for i := 0; i < len(commands); i++ {
@@ -39,34 +82,95 @@ func NewCommandWidget(bw *BaseWidget, opts WidgetConfig) *CommandWidget {
 		if len(colors) < i+1 {
 			colors = append(colors, DefaultColor)
 		}
+		if len(gauges) < i+1 {
+			gauges = append(gauges, "")
+		}
+		if gauges[i] != "" {
+			if err := checkGaugeStyle(gauges[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the graph shows the numbers printed by the first command
+	graph, err := newGraph(opts.Config, math.NaN(), math.NaN(), DefaultFillColor)
+	if err != nil {
+		return nil, err
 	}
 
 	return &CommandWidget{
 		BaseWidget: bw,
 		commands:   commands,
 		fonts:      fonts,
+		frameReps:  frameReps,
 		frames:     frames,
 		colors:     colors,
-	}
+		gauges:     gauges,
+		graph:      graph,
+		json:       jsonOutput,
+		alertColor: alertColor,
+		reported:   make([]bool, len(commands)),
+	}, nil
 }
 
 // Update renders the widget.
 func (w *CommandWidget) Update() error {
-	size := int(w.dev.Pixels)
-	img := image.NewRGBA(image.Rect(0, 0, size, size))
-
+	outputs := make([]string, len(w.commands))
 	for i := 0; i < len(w.commands); i++ {
 		str, err := runCommand(w.commands[i])
 		if err != nil {
 			return err
 		}
+		outputs[i] = str
+	}
+
+	return w.draw(outputs)
+}
+
+// draws the output of the commands.
+func (w *CommandWidget) draw(outputs []string) error {
+	size := int(w.dev.Pixels())
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	if w.json && len(outputs) > 0 {
+		out, err := parseCommandOutput(outputs[0])
+		if err == nil {
+			w.lastErr = ""
+			w.drawOutput(img, out)
+			return w.render(w.dev, img)
+		}
+
+		// show the output as is instead
+		if err.Error() != w.lastErr {
+			fmt.Fprintf(os.Stderr, "Can't parse output of %s: %s\n", w.commands[0], err)
+			w.lastErr = err.Error()
+		}
+	}
+
+	if w.graph != nil && len(outputs) > 0 {
+		if v, ok := w.number(0, outputs[0]); ok {
+			w.graph.add(v)
+		}
+		margin := size / 18
+		w.graph.draw(img, image.Rect(margin, margin, size-margin, size-margin))
+	}
+
+	for i, str := range outputs {
+		// commands with a gauge print a percentage
+		if w.gauges[i] != "" {
+			if v, ok := w.number(i, str); ok {
+				drawGauge(img, w.frames[i], w.gauges[i], v, w.colors[i], w.colors[i])
+			}
+			continue
+		}
+
 		font := fontByName(w.fonts[i])
 
 		drawString(img,
 			w.frames[i],
 			font,
 			str,
-			w.dev.DPI,
+			w.dev.DPI(),
 			-1,
 			w.colors[i],
 			image.Pt(-1, -1))
@@ -74,6 +178,139 @@ func (w *CommandWidget) Update() error {
 	return w.render(w.dev, img)
 }
 
+// parses the JSON output of a command.
+func parseCommandOutput(s string) (commandOutput, error) {
+	var out commandOutput
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return out, err
+	}
+
+	var clr color.Color
+	for _, c := range []string{out.Color, out.Background} {
+		if c == "" {
+			continue
+		}
+		if err := ConfigValue(c, &clr); err != nil {
+			return out, err
+		}
+	}
+
+	return out, nil
+}
+
+// draws the appearance a command printed in JSON mode. The label's lines go
+// into the frames of the widget's layout, or get stacked underneath the icon.
+func (w *CommandWidget) drawOutput(img *image.RGBA, out commandOutput) {
+	size := int(w.dev.Pixels())
+	margin := size / 18
+
+	if out.Background != "" {
+		var bg color.Color
+		_ = ConfigValue(out.Background, &bg)
+		draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+	if out.Alert {
+		drawBorder(img, img.Bounds(), margin, w.alertColor)
+	}
+
+	clr := w.colors[0]
+	if out.Color != "" {
+		_ = ConfigValue(out.Color, &clr)
+	}
+
+	// the space left for the icon and label
+	area := img.Bounds().Inset(margin * 2)
+	if out.Progress != nil {
+		height := size / 9
+		drawGauge(img,
+			image.Rect(area.Min.X, area.Max.Y-height, area.Max.X, area.Max.Y),
+			"hbar", *out.Progress, clr, clr)
+		area.Max.Y -= height + margin
+	}
+
+	var lines []string
+	if out.Label != "" {
+		lines = strings.Split(out.Label, "\n")
+	}
+
+	if icon := w.loadIcon(out.Icon); icon != nil {
+		height := area.Dy()
+		if len(lines) > 0 {
+			height = height * 2 / 3
+		}
+		if err := drawImage(img, icon, height, image.Pt(-1, area.Min.Y)); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't draw icon %s: %s\n", out.Icon, err)
+		}
+		area.Min.Y += height
+	}
+
+	var frames []image.Rectangle
+	if len(w.frameReps) >= len(lines) {
+		frames = NewLayout(size).FormatLayout(w.frameReps, len(lines))
+	} else {
+		for i := range lines {
+			frames = append(frames, image.Rect(
+				area.Min.X,
+				area.Min.Y+area.Dy()*i/len(lines),
+				area.Max.X,
+				area.Min.Y+area.Dy()*(i+1)/len(lines)))
+		}
+	}
+
+	for i, line := range lines {
+		font := ttfFont
+		if i < len(w.fonts) {
+			font = fontByName(w.fonts[i])
+		}
+
+		drawString(img,
+			frames[i],
+			font,
+			line,
+			w.dev.DPI(),
+			-1,
+			clr,
+			image.Pt(-1, -1))
+	}
+}
+
+// returns the icon at path, relative to the deck's directory. It only gets
+// loaded once while it doesn't change.
+func (w *CommandWidget) loadIcon(path string) image.Image {
+	if path == w.iconPath {
+		return w.icon
+	}
+	w.iconPath = path
+	w.icon = nil
+
+	if path == "" {
+		return nil
+	}
+	p, err := expandPath(w.base, path)
+	if err == nil {
+		w.icon, err = loadImage(p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't load icon %s: %s\n", path, err)
+	}
+
+	return w.icon
+}
+
+// returns the first number in the output of the i-th command.
+func (w *CommandWidget) number(i int, output string) (float64, bool) {
+	v, err := strconv.ParseFloat(commandNumber.FindString(output), 64)
+	if err != nil {
+		if !w.reported[i] {
+			fmt.Fprintf(os.Stderr, "Can't find a number in the output of %s: %q\n", w.commands[i], output)
+			w.reported[i] = true
+		}
+		return 0, false
+	}
+
+	return v, true
+}
+
 func runCommand(command string) (string, error) {
 	output, err := exec.Command("sh", "-c", command).Output()
 	if err != nil {
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// DBusWidget is a button displaying a value received from a D-Bus signal or
+// property.
+type DBusWidget struct {
+	*ButtonWidget
+
+	match    *signalMatch
+	property string
+	arg      int
+	key      []string
+	format   string
+	dirty    bool
+}
+
+// NewDBusWidget returns a new DBusWidget.
+func NewDBusWidget(bw *BaseWidget, opts WidgetConfig) (*DBusWidget, error) {
+	button, err := NewButtonWidget(bw, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var bus, sender, path, iface, member, property, key, format string
+	_ = ConfigValue(opts.Config["bus"], &bus)
+	_ = ConfigValue(opts.Config["sender"], &sender)
+	_ = ConfigValue(opts.Config["path"], &path)
+	_ = ConfigValue(opts.Config["interface"], &iface)
+	_ = ConfigValue(opts.Config["member"], &member)
+	_ = ConfigValue(opts.Config["property"], &property)
+	_ = ConfigValue(opts.Config["key"], &key)
+	_ = ConfigValue(opts.Config["format"], &format)
+	var arg int64
+	_ = ConfigValue(opts.Config["arg"], &arg)
+
+	if member == "" && property == "" {
+		return nil, errors.New("dbus widget needs a member or property")
+	}
+	if property != "" && (sender == "" || path == "" || iface == "") {
+		return nil, errors.New("dbus property needs a sender, path and interface")
+	}
+
+	match, err := newSignalMatch(bus, sender, path, iface, member)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &DBusWidget{
+		ButtonWidget: button,
+		match:        match,
+		property:     property,
+		arg:          int(arg),
+		format:       format,
+	}
+	if key != "" {
+		w.key = strings.Split(key, "/")
+	}
+
+	if property != "" {
+		bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, time.Second)
+	}
+	if member != "" {
+		if err := match.subscribe(); err != nil {
+			verbosef("Can't subscribe to signal %s: %s", match.rule(), err)
+		}
+
+		// show the last value received while another deck was active
+		if e, ok := lastSignals[match.key()]; ok {
+			w.Signal(e)
+		}
+	}
+
+	return w, nil
+}
+
+// Close stops the delivery of the widget's signal.
+func (w *DBusWidget) Close() {
+	w.match.unsubscribe()
+}
+
+// Signal updates the widget when a matching signal arrives.
+func (w *DBusWidget) Signal(e SignalEvent) {
+	if w.match.member == "" || !w.match.matches(e) {
+		return
+	}
+	lastSignals[w.match.key()] = e
+
+	// signals like PropertiesChanged don't always carry the desired value
+	v, ok := signalValue(e.Signal.Body, w.arg, w.key)
+	if !ok {
+		return
+	}
+
+	w.label = formatValue(w.format, v)
+	w.dirty = true
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *DBusWidget) RequiresUpdate() bool {
+	return w.dirty || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *DBusWidget) Update() error {
+	if w.property != "" && !w.dirty {
+		if v, err := w.queryProperty(); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't query dbus property %s: %s\n", w.property, err)
+		} else if v, ok := lookupValue(v, w.key); ok {
+			w.label = formatValue(w.format, v)
+		}
+	}
+	w.dirty = false
+
+	return w.ButtonWidget.Update()
+}
+
+// queries the widget's property.
+func (w *DBusWidget) queryProperty() (interface{}, error) {
+	conn, err := busConn(w.match.bus)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := conn.Object(w.match.sender, dbus.ObjectPath(w.match.path)).
+		GetProperty(w.match.iface + "." + w.property)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Value(), nil
+}
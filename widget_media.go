@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // album art is usually a JPEG
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"github.com/nfnt/resize"
+)
+
+const (
+	// how often scrolling text moves on
+	scrollInterval = 150 * time.Millisecond
+	// how long fetching album art may take
+	artTimeout = 5 * time.Second
+)
+
+// MediaWidget shows the state of the most recently active MPRIS media player.
+type MediaWidget struct {
+	*BaseWidget
+
+	color   color.Color
+	players *mediaPlayers
+	matches []*signalMatch
+	dirty   bool
+
+	// scroll offset of texts exceeding the key's width, in pixels
+	scroll    int
+	scrolling bool
+	shown     string
+
+	artMutex sync.Mutex
+	artURL   string
+	art      image.Image
+	artFresh bool
+}
+
+// NewMediaWidget returns a new MediaWidget.
+func NewMediaWidget(bw *BaseWidget, opts WidgetConfig) (*MediaWidget, error) {
+	var player string
+	_ = ConfigValue(opts.Config["player"], &player)
+	var clr color.Color
+	_ = ConfigValue(opts.Config["color"], &clr)
+	if clr == nil {
+		clr = DefaultColor
+	}
+
+	w := &MediaWidget{
+		BaseWidget: bw,
+		color:      clr,
+		players:    newMediaPlayers(player),
+	}
+
+	for _, m := range [][]string{
+		{"org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "NameOwnerChanged"},
+		{"", mprisPath, "org.freedesktop.DBus.Properties", "PropertiesChanged"},
+	} {
+		match, _ := newSignalMatch("session", m[0], m[1], m[2], m[3])
+		if err := match.subscribe(); err != nil {
+			verbosef("Can't subscribe to signal %s: %s", match.rule(), err)
+		}
+		w.matches = append(w.matches, match)
+	}
+
+	if dbusConn != nil {
+		go func(conn *dbus.Conn) {
+			if err := w.players.discover(conn); err != nil {
+				fmt.Fprintf(os.Stderr, "Can't discover media players: %s\n", err)
+			}
+		}(dbusConn)
+	}
+
+	return w, nil
+}
+
+// Action returns the associated ActionConfig. Without one, it toggles
+// playback.
+func (w *MediaWidget) Action() *ActionConfig {
+	if w.action != nil {
+		return w.action
+	}
+	if p := w.players.current(); p != nil {
+		return p.action("PlayPause")
+	}
+
+	return nil
+}
+
+// ActionHold returns the associated ActionConfig for long presses. Without
+// one, it skips to the next track.
+func (w *MediaWidget) ActionHold() *ActionConfig {
+	if w.actionHold != nil {
+		return w.actionHold
+	}
+	if p := w.players.current(); p != nil {
+		return p.action("Next")
+	}
+
+	return nil
+}
+
+// Signal updates the widget when a player changes.
+func (w *MediaWidget) Signal(e SignalEvent) {
+	for _, m := range w.matches {
+		if m.matches(e) && w.players.handleSignal(dbusConn, e.Signal) {
+			w.dirty = true
+		}
+	}
+}
+
+// Close stops the delivery of the players' signals.
+func (w *MediaWidget) Close() {
+	for _, m := range w.matches {
+		m.unsubscribe()
+	}
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *MediaWidget) RequiresUpdate() bool {
+	if w.scrolling && time.Since(w.lastUpdate) >= scrollInterval {
+		return true
+	}
+
+	if w.players.applyFetched() {
+		w.dirty = true
+	}
+
+	w.artMutex.Lock()
+	fresh := w.artFresh
+	w.artMutex.Unlock()
+	if fresh {
+		return true
+	}
+
+	return w.dirty || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *MediaWidget) Update() error {
+	w.dirty = false
+
+	size := int(w.dev.Pixels())
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	p := w.players.current()
+	if p == nil {
+		w.scrolling = false
+		drawString(img,
+			image.Rect(0, 0, size, size).Inset(size/8),
+			ttfThinFont,
+			"No player",
+			w.dev.DPI(),
+			-1,
+			w.color,
+			image.Pt(-1, -1))
+
+		return w.render(w.dev, img)
+	}
+
+	margin := size / 18
+	textTop := size * 3 / 5
+	art := w.albumArt(p.artURL)
+	if art != nil {
+		draw.Draw(img, img.Bounds(), art, image.Point{}, draw.Src)
+
+		// darken the art behind the text
+		draw.Draw(img,
+			image.Rect(0, textTop, size, size),
+			image.NewUniform(color.RGBA{0, 0, 0, 160}),
+			image.Point{}, draw.Over)
+
+		iconSize := size / 5
+		drawPlayState(img,
+			image.Rect(size-margin-iconSize, margin, size-margin, margin+iconSize),
+			p.status, w.color)
+	} else {
+		iconSize := (textTop - margin) * 2 / 3
+		top := (textTop - iconSize) / 2
+		drawPlayState(img,
+			image.Rect((size-iconSize)/2, top, (size+iconSize)/2, top+iconSize),
+			p.status, w.color)
+	}
+
+	// scroll from the start whenever another track gets shown
+	if shown := p.title + "\n" + p.artist; shown != w.shown {
+		w.shown = shown
+		w.scroll = 0
+	}
+
+	lineHeight := (size - textTop - margin) / 2
+	title := image.Rect(margin, textTop, size-margin, textTop+lineHeight)
+	artist := image.Rect(margin, textTop+lineHeight, size-margin, textTop+2*lineHeight)
+
+	w.scrolling = false
+	w.scrolling = w.drawLine(img, title, ttfBoldFont, p.title) || w.scrolling
+	w.scrolling = w.drawLine(img, artist, ttfFont, p.artist) || w.scrolling
+	if w.scrolling {
+		w.scroll += size/36 + 1
+	}
+
+	return w.render(w.dev, img)
+}
+
+// draws a line of text into bounds, scrolling it horizontally if it doesn't
+// fit. It returns true if the text is scrolling.
+func (w *MediaWidget) drawLine(img *image.RGBA, bounds image.Rectangle, ttf *truetype.Font, text string) bool {
+	if text == "" {
+		return false
+	}
+
+	// font size in points for the line's height in pixels
+	fontsize := float64(bounds.Dy()) * 72 / float64(w.dev.DPI()) * 0.8
+	width := textWidth(img, ttf, w.dev.DPI(), fontsize, text)
+	dst := img.SubImage(bounds).(*image.RGBA)
+
+	if width <= bounds.Dx() {
+		drawString(dst, bounds, ttf, text, w.dev.DPI(), fontsize, w.color, image.Pt(-1, -1))
+		return false
+	}
+
+	// render the whole text once, then copy the visible part into the key
+	line := image.NewRGBA(image.Rect(0, 0, width, bounds.Dy()))
+	drawString(line, line.Bounds(), ttf, text, w.dev.DPI(), fontsize, w.color, image.Pt(0, -1))
+
+	// draw the text twice, so its start follows its end
+	gap := bounds.Dy() * 2
+	offset := w.scroll % (width + gap)
+	for _, x := range []int{bounds.Min.X - offset, bounds.Min.X - offset + width + gap} {
+		r := image.Rect(x, bounds.Min.Y, x+width, bounds.Max.Y)
+		draw.Draw(dst, r, line, image.Point{}, draw.Over)
+	}
+
+	return true
+}
+
+// returns the width of text in pixels.
+func textWidth(img *image.RGBA, ttf *truetype.Font, dpi uint, fontsize float64, text string) int {
+	extent, err := ftContext(img, ttf, dpi, fontsize).DrawString(text, freetype.Pt(0, 0))
+	if err != nil {
+		return 0
+	}
+
+	return extent.X.Ceil()
+}
+
+// albumArt returns the album art at u, scaled to the key's size. Remote
+// images get fetched in the background, and the widget gets repainted once
+// they're available.
+func (w *MediaWidget) albumArt(u string) image.Image {
+	w.artMutex.Lock()
+	defer w.artMutex.Unlock()
+
+	w.artFresh = false
+	if u == w.artURL {
+		return w.art
+	}
+	w.artURL = u
+	w.art = nil
+
+	pu, err := url.Parse(u)
+	if err != nil || u == "" {
+		return nil
+	}
+
+	switch pu.Scheme {
+	case "file":
+		art, err := loadImage(pu.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't load album art: %s\n", err)
+			return nil
+		}
+		w.art = w.scaleArt(art)
+
+	case "http", "https":
+		go func() {
+			art, err := fetchImage(u)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't fetch album art: %s\n", err)
+				return
+			}
+
+			w.artMutex.Lock()
+			defer w.artMutex.Unlock()
+			if w.artURL == u {
+				w.art = w.scaleArt(art)
+				w.artFresh = true
+			}
+		}()
+	}
+
+	return w.art
+}
+
+// scales album art to the key's size.
+func (w *MediaWidget) scaleArt(art image.Image) image.Image {
+	size := uint(w.dev.Pixels())
+	return resize.Resize(size, size, art, resize.Bilinear)
+}
+
+// fetches an image from the web.
+func fetchImage(u string) (image.Image, error) {
+	client := http.Client{Timeout: artTimeout}
+	resp, err := client.Get(u) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	return img, err
+}
+
+// draws a symbol for a playback status into r.
+func drawPlayState(img *image.RGBA, r image.Rectangle, status string, clr color.Color) {
+	src := image.NewUniform(clr)
+
+	switch status {
+	case "Playing":
+		// a triangle pointing right
+		for x := r.Min.X; x < r.Max.X; x++ {
+			inset := (x - r.Min.X) * r.Dy() / r.Dx() / 2
+			draw.Draw(img, image.Rect(x, r.Min.Y+inset, x+1, r.Max.Y-inset), src, image.Point{}, draw.Over)
+		}
+
+	case "Paused":
+		bar := r.Dx() * 3 / 10
+		draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+bar, r.Max.Y), src, image.Point{}, draw.Over)
+		draw.Draw(img, image.Rect(r.Max.X-bar, r.Min.Y, r.Max.X, r.Max.Y), src, image.Point{}, draw.Over)
+
+	default:
+		draw.Draw(img, r.Inset(r.Dx()/10), src, image.Point{}, draw.Over)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+)
+
+// OverrideWidget temporarily replaces the appearance of another widget, while
+// keeping its actions.
+type OverrideWidget struct {
+	*ButtonWidget
+
+	widget Widget
+	until  time.Time
+}
+
+// NewOverrideWidget returns a new OverrideWidget for widget. A zero timeout
+// keeps the override until it gets cleared or the deck changes.
+func NewOverrideWidget(bw *BaseWidget, widget Widget, opts WidgetConfig, timeout time.Duration) (*OverrideWidget, error) {
+	button, err := NewButtonWidget(bw, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &OverrideWidget{
+		ButtonWidget: button,
+		widget:       widget,
+	}
+	if timeout > 0 {
+		w.until = time.Now().Add(timeout)
+	}
+
+	return w, nil
+}
+
+// Widget returns the widget being overridden.
+func (w *OverrideWidget) Widget() Widget {
+	return w.widget
+}
+
+// Expired returns true when the override timed out.
+func (w *OverrideWidget) Expired() bool {
+	return !w.until.IsZero() && time.Now().After(w.until)
+}
+
+// Action returns the associated ActionConfig.
+func (w *OverrideWidget) Action() *ActionConfig {
+	return w.widget.Action()
+}
+
+// ActionHold returns the associated ActionConfig for long presses.
+func (w *OverrideWidget) ActionHold() *ActionConfig {
+	return w.widget.ActionHold()
+}
+
+// TriggerAction gets called when a button is pressed.
+func (w *OverrideWidget) TriggerAction(hold bool) {
+	w.widget.TriggerAction(hold)
+}
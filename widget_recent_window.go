@@ -13,7 +13,7 @@ type RecentWindowWidget struct {
 	window    uint8
 	showTitle bool
 
-	lastID uint32
+	lastID uint64
 }
 
 // NewRecentWindowWidget returns a new RecentWindowWidget.
@@ -48,7 +48,7 @@ func (w *RecentWindowWidget) RequiresUpdate() bool {
 
 // Update renders the widget.
 func (w *RecentWindowWidget) Update() error {
-	img := image.NewRGBA(image.Rect(0, 0, int(w.dev.Pixels), int(w.dev.Pixels)))
+	img := image.NewRGBA(image.Rect(0, 0, int(w.dev.Pixels()), int(w.dev.Pixels())))
 
 	if int(w.window) < len(recentWindows) {
 		if w.lastID == recentWindows[w.window].ID {
@@ -59,10 +59,13 @@ func (w *RecentWindowWidget) Update() error {
 		var name string
 		if w.showTitle {
 			name = recentWindows[w.window].Name
-			runes := []rune(name)
-			if len(runes) > 10 {
-				name = string(runes[:10])
-			}
+		} else if recentWindows[w.window].Icon == nil {
+			// not all window managers provide icons
+			name = recentWindows[w.window].Class
+		}
+		runes := []rune(name)
+		if len(runes) > 10 {
+			name = string(runes[:10])
 		}
 
 		w.label = name
@@ -75,17 +78,17 @@ func (w *RecentWindowWidget) Update() error {
 
 // TriggerAction gets called when a button is pressed.
 func (w *RecentWindowWidget) TriggerAction(hold bool) {
-	if xorg == nil {
-		fmt.Fprintln(os.Stderr, "xorg support is disabled!")
+	if windowTracker == nil {
+		fmt.Fprintln(os.Stderr, "Window tracking is disabled!")
 		return
 	}
 
 	if int(w.window) < len(recentWindows) {
 		if hold {
-			_ = xorg.CloseWindow(recentWindows[w.window])
+			_ = windowTracker.CloseWindow(recentWindows[w.window])
 			return
 		}
 
-		_ = xorg.RequestActivation(recentWindows[w.window])
+		_ = windowTracker.RequestActivation(recentWindows[w.window])
 	}
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// how long to wait before restarting a command that exited
+	streamMinBackoff = time.Second
+	// the longest wait before restarting a command
+	streamMaxBackoff = time.Minute
+)
+
+// StreamWidget is a widget running a command in the background, showing each
+// line it prints.
+type StreamWidget struct {
+	*CommandWidget
+
+	mutex sync.Mutex
+	line  string
+	dirty bool
+	cmd   *exec.Cmd
+	done  chan struct{}
+}
+
+// NewStreamWidget returns a new StreamWidget and starts its command.
+func NewStreamWidget(bw *BaseWidget, opts WidgetConfig) (*StreamWidget, error) {
+	cw, err := NewCommandWidget(bw, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(cw.commands) != 1 {
+		return nil, fmt.Errorf("stream widget needs exactly one command, got %d", len(cw.commands))
+	}
+	// only repaint when a line arrives
+	bw.interval = 0
+
+	w := &StreamWidget{
+		CommandWidget: cw,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *StreamWidget) RequiresUpdate() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.dirty || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *StreamWidget) Update() error {
+	w.mutex.Lock()
+	line, dirty := w.line, w.dirty
+	w.dirty = false
+	w.mutex.Unlock()
+
+	if !dirty {
+		// nothing got printed yet
+		return w.render(w.dev, nil)
+	}
+
+	return w.draw([]string{line})
+}
+
+// Close stops the command.
+func (w *StreamWidget) Close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	close(w.done)
+
+	if w.cmd != nil && w.cmd.Process != nil {
+		// kill the whole process group, including the command's children
+		_ = syscall.Kill(-w.cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
+
+// runs the command until the widget gets closed, restarting it with an
+// increasing delay whenever it exits.
+func (w *StreamWidget) run() {
+	backoff := streamMinBackoff
+	for {
+		started := time.Now()
+		err := w.follow()
+
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+
+		// commands that ran for a while get restarted quickly again
+		if time.Since(started) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+		fmt.Fprintf(os.Stderr, "Command %s exited (%v), restarting in %s\n", w.commands[0], err, backoff)
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// starts the command and reads its output until it exits.
+func (w *StreamWidget) follow() error {
+	cmd := exec.Command("sh", "-c", w.commands[0])
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	select {
+	case <-w.done:
+		w.mutex.Unlock()
+		return nil
+	default:
+	}
+	err = cmd.Start()
+	w.cmd = cmd
+	w.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		w.mutex.Lock()
+		w.line = scanner.Text()
+		w.dirty = true
+		w.mutex.Unlock()
+	}
+
+	return cmd.Wait()
+}
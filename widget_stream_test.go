@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waits until the widget has shown line.
+func waitForLine(t *testing.T, w *StreamWidget, line string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mutex.Lock()
+		got := w.line
+		w.mutex.Unlock()
+
+		if got == line {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for line %q", line)
+}
+
+func TestStreamWidget(t *testing.T) {
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		w := newTestWidget(t, dev, "stream", map[string]interface{}{
+			"command": "printf 'one\\ntwo\\n' && exec sleep 30",
+		}).(*StreamWidget)
+		d := &Deck{Widgets: []Widget{w}}
+		defer d.close()
+
+		waitForLine(t, w, "two")
+		if !w.RequiresUpdate() {
+			t.Error("expected widget to require an update")
+		}
+		if err := w.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if w.RequiresUpdate() {
+			t.Error("expected widget to be up to date")
+		}
+
+		assertGolden(t, fmt.Sprintf("stream_%d", dev.Pixels()), dev.KeyImage(0))
+
+		w.mutex.Lock()
+		pid := w.cmd.Process.Pid
+		w.mutex.Unlock()
+
+		// closing the deck kills the command
+		d.close()
+		deadline := time.Now().Add(5 * time.Second)
+		for syscall.Kill(pid, 0) == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("command is still running")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestStreamWidgetRestart(t *testing.T) {
+	orig := streamMinBackoff
+	streamMinBackoff = 10 * time.Millisecond
+	defer func() { streamMinBackoff = orig }()
+
+	count := filepath.Join(t.TempDir(), "count")
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	w := newTestWidget(t, dev, "stream", map[string]interface{}{
+		"command": "echo run >> " + count + " && wc -l < " + count,
+	}).(*StreamWidget)
+	defer w.Close()
+
+	// the command exits right away, and gets restarted
+	waitForLine(t, w, "3")
+}
+
+func TestStreamWidgetCommands(t *testing.T) {
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	if _, err := NewWidget(dev, "decks/main.deck", KeyConfig{Widget: WidgetConfig{
+		ID:     "stream",
+		Config: map[string]interface{}{"command": "echo one;echo two"},
+	}}, nil); err == nil {
+		t.Error("expected error for multiple commands")
+	}
+}
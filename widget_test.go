@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/shirou/gopsutil/cpu"
+)
+
+func newTestWidget(t *testing.T, dev Device, id string, config map[string]interface{}) Widget {
+	t.Helper()
+
+	kc := KeyConfig{
+		Widget: WidgetConfig{
+			ID:     id,
+			Config: config,
+		},
+	}
+	bg := image.NewRGBA(image.Rect(0, 0, int(dev.Pixels()), int(dev.Pixels())))
+
+	w, err := NewWidget(dev, "decks/main.deck", kc, bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestButtonWidget(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"label", map[string]interface{}{
+			"label": "Label",
+		}},
+		{"icon", map[string]interface{}{
+			"icon": "assets/go-next.png",
+		}},
+		{"icon_label", map[string]interface{}{
+			"icon":     "assets/volume-high.png",
+			"label":    "Raise Vol",
+			"fontsize": int64(8),
+		}},
+		{"flatten", map[string]interface{}{
+			"icon":    "assets/go-previous.png",
+			"label":   "Dim",
+			"color":   "#d497de",
+			"flatten": true,
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "button", tt.config)
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("button_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestTimeWidget(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time {
+		return time.Date(2021, time.March, 14, 15, 9, 26, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		config map[string]interface{}
+	}{
+		{"clock", "clock", nil},
+		{"date", "date", nil},
+		{"layout", "time", map[string]interface{}{
+			"format": "%H:%i;%Y",
+			"font":   "bold;thin",
+			"color":  "#fefefe;#d497de",
+			"layout": "0x0+72x48;0x48+72x24",
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, tt.id, tt.config)
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("time_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestTopWidget(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		label  string
+		config map[string]interface{}
+	}{
+		{"cpu", 42, "CPU", map[string]interface{}{
+			"mode":      "cpu",
+			"fillColor": "#d497de",
+		}},
+		{"memory", 87.5, "MEM", map[string]interface{}{
+			"mode": "memory",
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "top", tt.config).(*TopWidget)
+				if err := w.renderValue(tt.value, tt.label); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("top_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestTopSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample topSample
+		config map[string]interface{}
+	}{
+		{"network", topSample{percent: 30, text: "1.5", unit: "MB/s", label: "RX"}, map[string]interface{}{
+			"mode":      "network",
+			"direction": "rx",
+		}},
+		{"temperature", topSample{percent: 54, text: "54", unit: "°C"}, map[string]interface{}{
+			"mode": "temperature",
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "top", tt.config).(*TopWidget)
+				if err := w.renderSample(tt.sample); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("top_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestTopRateSample(t *testing.T) {
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	w := newTestWidget(t, dev, "top", map[string]interface{}{
+		"mode":      "network",
+		"direction": "tx",
+		"max":       int64(4000),
+	}).(*TopWidget)
+
+	if s := w.rateSample([2]uint64{1000, 1000}, map[string]string{"tx": "TX"}); s.text != "0" || s.percent != 0 {
+		t.Errorf("expected no rate without a previous sample, got %+v", s)
+	}
+
+	w.lastSampled = time.Now().Add(-time.Second)
+	s := w.rateSample([2]uint64{9000, 3000}, map[string]string{"tx": "TX"})
+	if s.unit != "KB/s" || s.label != "TX" || math.Abs(s.percent-50) > 1 {
+		t.Errorf("expected a rate of 2 KB/s at 50%%, got %+v", s)
+	}
+}
+
+func TestCPUUsage(t *testing.T) {
+	prev := cpu.TimesStat{User: 10, System: 10, Idle: 70, Iowait: 10}
+	cur := cpu.TimesStat{User: 40, System: 20, Idle: 100, Iowait: 20}
+
+	if got := cpuUsage(prev, cur); got != 50 {
+		t.Errorf("expected 50%%, got %v", got)
+	}
+	if got := cpuUsage(cur, cur); got != 0 {
+		t.Errorf("expected 0%% without elapsed time, got %v", got)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate       float64
+		text, unit string
+	}{
+		{0, "0", "B/s"},
+		{999, "999", "B/s"},
+		{1500, "1.5", "KB/s"},
+		{42000000, "42", "MB/s"},
+		{3e12, "3000", "GB/s"},
+	}
+
+	for _, tt := range tests {
+		if text, unit := formatRate(tt.rate); text != tt.text || unit != tt.unit {
+			t.Errorf("%v: expected %s %s, got %s %s", tt.rate, tt.text, tt.unit, text, unit)
+		}
+	}
+}
+
+func TestCheckTopMode(t *testing.T) {
+	tests := []struct {
+		mode, direction string
+		err             bool
+	}{
+		{"cpu", "", false},
+		{"diskio", "write", false},
+		{"network", "rx", false},
+		{"gpu", "", true},
+		{"network", "read", true},
+		{"memory", "rx", true},
+	}
+
+	for _, tt := range tests {
+		if err := checkTopMode(tt.mode, tt.direction); (err != nil) != tt.err {
+			t.Errorf("%s/%s: expected error %t, got %v", tt.mode, tt.direction, tt.err, err)
+		}
+	}
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	if _, err := NewWidget(dev, "decks/main.deck", KeyConfig{Widget: WidgetConfig{
+		ID:     "top",
+		Config: map[string]interface{}{"mode": "gpu"},
+	}}, nil); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestCommandJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"alert", `{"label": "CI\nfailed", "icon": "assets/go-next.png", "background": "#602020", "alert": true}`},
+		{"progress", `{"label": "Upload", "color": "#ffcc00", "progress": 75}`},
+		{"icon", `{"icon": "assets/volume-high.png"}`},
+		{"invalid", `{"label": "Oops"`},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "command", map[string]interface{}{
+					"command": "printf '%s' '" + tt.output + "'",
+					"json":    true,
+				})
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("command_json_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestParseCommandOutput(t *testing.T) {
+	out, err := parseCommandOutput(`{"label": "Build", "progress": 40.5, "alert": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Label != "Build" || out.Progress == nil || *out.Progress != 40.5 || !out.Alert {
+		t.Errorf("unexpected output %+v", out)
+	}
+
+	for _, s := range []string{`Build`, `{"color": "red"}`, `{"background": "#12"}`, `{"progress": "full"}`} {
+		if _, err := parseCommandOutput(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestVolumeWidget(t *testing.T) {
+	fakePactl(t, map[string]string{
+		"get-sink-volume @DEFAULT_SINK@":     "Volume: front-left: 42598 /  65% / -11.23 dB\n",
+		"get-sink-mute @DEFAULT_SINK@":       "Mute: no\n",
+		"get-source-volume @DEFAULT_SOURCE@": "Volume: mono: 52429 /  80% / -5.81 dB\n",
+		"get-source-mute @DEFAULT_SOURCE@":   "Mute: yes\n",
+	})
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"sink", map[string]interface{}{}},
+		{"source_muted", map[string]interface{}{"device": "source"}},
+		{"unknown", map[string]interface{}{"name": "missing"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "volume", tt.config).(*VolumeWidget)
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("volume_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	w := newTestWidget(t, dev, "volume", map[string]interface{}{"device": "source"}).(*VolumeWidget)
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if w.RequiresUpdate() {
+		t.Error("expected widget to be up to date")
+	}
+	if a := w.Action(); a == nil || a.Volume == nil || a.Volume.Device != "source" || a.Volume.Mute != "toggle" {
+		t.Errorf("expected action toggling the source's mute state, got %+v", a)
+	}
+	if err := adjustVolume(*w.Action().Volume); err != nil {
+		t.Fatal(err)
+	}
+	if !w.RequiresUpdate() {
+		t.Error("expected widget to require an update after changing the volume")
+	}
+
+	// errors only get reported once
+	w = newTestWidget(t, dev, "volume", map[string]interface{}{"name": "missing"}).(*VolumeWidget)
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.lastErr != "no such entity" {
+		t.Errorf("expected last error to be remembered, got %q", w.lastErr)
+	}
+}
+
+func TestBatteryWidget(t *testing.T) {
+	fakePowerSupplies(t, testPowerSupplies)
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"system", map[string]interface{}{}},
+		{"warn", map[string]interface{}{"warn": int64(70)}},
+		{"charging", map[string]interface{}{"device": "hidpp_battery_0"}},
+		{"missing", map[string]interface{}{"device": "BAT1"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "battery", tt.config)
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("battery_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+}
+
+func TestDrawString(t *testing.T) {
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		size := int(dev.Pixels())
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+		// centered and auto-sized
+		drawString(img, image.Rect(0, 0, size, size/2), ttfBoldFont,
+			"Fit", dev.DPI(), -1, DefaultColor, image.Pt(-1, -1))
+		// fixed font size and position
+		drawString(img, image.Rect(0, size/2, size, size), ttfFont,
+			"8pt", dev.DPI(), 8, color.RGBA{212, 151, 222, 255}, image.Pt(2, size-4))
+
+		assertGolden(t, fmt.Sprintf("draw_string_%d", size), img)
+	})
+}
+
+func TestDrawImage(t *testing.T) {
+	icon, err := loadImage("decks/assets/go-next.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+		size := int(dev.Pixels())
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+		// centered
+		if err := drawImage(img, icon, size/2, image.Pt(-1, -1)); err != nil {
+			t.Fatal(err)
+		}
+		// fixed position
+		if err := drawImage(img, icon, size/4, image.Pt(0, 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		assertGolden(t, fmt.Sprintf("draw_image_%d", size), img)
+	})
+}
+
+func TestToggleWidget(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	config := map[string]interface{}{
+		"name":      "mic",
+		"label":     "Off",
+		"labelOn":   "On",
+		"actionOn":  map[string]interface{}{"exec": "mic-on"},
+		"actionOff": map[string]interface{}{"exec": "mic-off"},
+	}
+
+	w := newTestWidget(t, dev, "toggle", config).(*ToggleWidget)
+	if w.On() {
+		t.Fatal("expected toggle to start switched off")
+	}
+	if a := w.Action(); a == nil || a.Exec != "mic-on" {
+		t.Fatalf("expected action mic-on, got %+v", a)
+	}
+
+	w.Toggle()
+	if !w.On() {
+		t.Fatal("expected toggle to be switched on")
+	}
+	if a := w.Action(); a == nil || a.Exec != "mic-off" {
+		t.Fatalf("expected action mic-off, got %+v", a)
+	}
+	if !w.RequiresUpdate() {
+		t.Fatal("expected toggled widget to require an update")
+	}
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the on state renders like a button with the on label
+	want := NewVirtualDevice(virtualModels["mini"], "")
+	if err := newTestWidget(t, want, "button", map[string]interface{}{"label": "On"}).Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(toRGBA(dev.KeyImage(0)).Pix, toRGBA(want.KeyImage(0)).Pix) {
+		t.Error("expected toggle to render its on state")
+	}
+
+	// the state survives re-creating the widget
+	w = newTestWidget(t, dev, "toggle", config).(*ToggleWidget)
+	if !w.On() {
+		t.Fatal("expected toggle state to be persisted")
+	}
+
+	// a state command overrides the persisted state. It runs in the
+	// background, and its result gets applied by the next update.
+	config["state"] = "exit 1"
+	w = newTestWidget(t, dev, "toggle", config).(*ToggleWidget)
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	waitForToggleState(t, w)
+	if !w.On() {
+		t.Fatal("expected state to be applied by an update")
+	}
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if w.On() {
+		t.Fatal("expected state command to switch the toggle off")
+	}
+
+	// results from before a toggle get discarded
+	config["state"] = "sleep 0.1; exit 1"
+	w = newTestWidget(t, dev, "toggle", config).(*ToggleWidget)
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	w.Toggle()
+	time.Sleep(300 * time.Millisecond)
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.On() {
+		t.Fatal("expected stale state to be discarded")
+	}
+
+	// without a name, toggles on the same key of different decks don't share
+	// their state
+	unnamed := KeyConfig{Widget: WidgetConfig{ID: "toggle"}}
+	bg := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	a, err := NewWidget(dev, "decks/a.deck", unnamed, bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.(*ToggleWidget).Toggle()
+
+	b, err := NewWidget(dev, "decks/b.deck", unnamed, bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(*ToggleWidget).On() {
+		t.Error("expected toggle of another deck to be switched off")
+	}
+}
+
+// waits until the state command of a toggle finished.
+func waitForToggleState(t *testing.T, w *ToggleWidget) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mutex.Lock()
+		queried := w.queried
+		w.mutex.Unlock()
+
+		if queried {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the toggle state")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDBusWidget(t *testing.T) {
+	defer func() {
+		lastSignals = make(map[string]SignalEvent)
+	}()
+
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	config := map[string]interface{}{
+		"label":     "-",
+		"interface": "org.freedesktop.DBus.Properties",
+		"member":    "PropertiesChanged",
+		"path":      "/org/mpris/MediaPlayer2",
+		"arg":       int64(1),
+		"key":       "Metadata/xesam:artist",
+		"format":    "by %s",
+	}
+	w := newTestWidget(t, dev, "dbus", config).(*DBusWidget)
+
+	changed := func(path string, props map[string]dbus.Variant) SignalEvent {
+		return SignalEvent{
+			Bus: "session",
+			Signal: &dbus.Signal{
+				Sender: ":1.42",
+				Path:   dbus.ObjectPath(path),
+				Name:   "org.freedesktop.DBus.Properties.PropertiesChanged",
+				Body:   []interface{}{"org.mpris.MediaPlayer2.Player", props, []string{}},
+			},
+		}
+	}
+	metadata := map[string]dbus.Variant{
+		"Metadata": dbus.MakeVariant(map[string]dbus.Variant{
+			"xesam:artist": dbus.MakeVariant([]string{"Muse", "Queen"}),
+		}),
+	}
+
+	tests := []struct {
+		event SignalEvent
+		label string
+	}{
+		// other objects get ignored
+		{changed("/org/other", metadata), "-"},
+		// as do signals without the desired value
+		{changed("/org/mpris/MediaPlayer2", map[string]dbus.Variant{
+			"PlaybackStatus": dbus.MakeVariant("Playing"),
+		}), "-"},
+		{changed("/org/mpris/MediaPlayer2", metadata), "by Muse, Queen"},
+	}
+	for _, tt := range tests {
+		w.Signal(tt.event)
+		if w.label != tt.label {
+			t.Errorf("expected label %q, got %q", tt.label, w.label)
+		}
+	}
+	if !w.RequiresUpdate() {
+		t.Error("expected widget to require an update after a signal")
+	}
+
+	// widgets created later show the last value
+	w = newTestWidget(t, dev, "dbus", config).(*DBusWidget)
+	if w.label != "by Muse, Queen" {
+		t.Errorf("expected last value to be restored, got %q", w.label)
+	}
+}
+
+func TestMediaWidget(t *testing.T) {
+	tests := []struct {
+		name    string
+		signals []*dbus.Signal
+	}{
+		{"none", nil},
+		{"playing", []*dbus.Signal{
+			ownerChanged(mprisPrefix+"spotify", "", ":1.10"),
+			playerChanged(":1.10", "Playing", "Uprising", "Muse"),
+		}},
+		{"paused", []*dbus.Signal{
+			ownerChanged(mprisPrefix+"spotify", "", ":1.10"),
+			playerChanged(":1.10", "Paused", "Knights of Cydonia", "Muse"),
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			forEachModel(t, func(t *testing.T, dev *VirtualDevice) {
+				w := newTestWidget(t, dev, "media", nil).(*MediaWidget)
+				for _, sig := range tt.signals {
+					w.Signal(SignalEvent{Bus: "session", Signal: sig})
+				}
+				if err := w.Update(); err != nil {
+					t.Fatal(err)
+				}
+
+				assertGolden(t, fmt.Sprintf("media_%s_%d", tt.name, dev.Pixels()), dev.KeyImage(0))
+			})
+		})
+	}
+
+	// long titles scroll, and the player can be controlled without actions
+	dev := NewVirtualDevice(virtualModels["mini"], "")
+	w := newTestWidget(t, dev, "media", nil).(*MediaWidget)
+	if w.Action() != nil {
+		t.Error("expected no action without a player")
+	}
+	w.Signal(SignalEvent{Bus: "session", Signal: ownerChanged(mprisPrefix+"spotify", "", ":1.10")})
+	w.Signal(SignalEvent{Bus: "session", Signal: playerChanged(":1.10", "Playing",
+		"Supermassive Black Hole (Live at Wembley Stadium)", "Muse")})
+	if !w.RequiresUpdate() {
+		t.Fatal("expected widget to require an update after a signal")
+	}
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.scrolling {
+		t.Error("expected long title to scroll")
+	}
+
+	// scrolled past the left margin, the title starts outside of the key
+	w.scroll = 40
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "media_scrolled_80", dev.KeyImage(0))
+	if a := w.ActionHold(); a == nil || a.DBus.Method != mprisPlayer+".Next" {
+		t.Errorf("expected Next as hold action, got %+v", a)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"time"
 )
 
+// timeNow returns the current time. It can be replaced for testing.
+var timeNow = time.Now
+
 // TimeWidget is a widget displaying the current time/date.
 type TimeWidget struct {
 	*BaseWidget
@@ -28,7 +31,7 @@ func NewTimeWidget(bw *BaseWidget, opts WidgetConfig) *TimeWidget {
 	var colors []color.Color
 	_ = ConfigValue(opts.Config["color"], &colors)
 
-	layout := NewLayout(int(bw.dev.Pixels))
+	layout := NewLayout(int(bw.dev.Pixels()))
 	frames := layout.FormatLayout(frameReps, len(formats))
 
 	for i := 0; i < len(formats); i++ {
@@ -51,18 +54,18 @@ func NewTimeWidget(bw *BaseWidget, opts WidgetConfig) *TimeWidget {
 
 // Update renders the widget.
 func (w *TimeWidget) Update() error {
-	size := int(w.dev.Pixels)
+	size := int(w.dev.Pixels())
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
 
 	for i := 0; i < len(w.formats); i++ {
-		str := formatTime(time.Now(), w.formats[i])
+		str := formatTime(timeNow(), w.formats[i])
 		font := fontByName(w.fonts[i])
 
 		drawString(img,
 			w.frames[i],
 			font,
 			str,
-			w.dev.DPI,
+			w.dev.DPI(),
 			-1,
 			w.colors[i],
 			image.Pt(-1, -1))
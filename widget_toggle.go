@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long a toggle's state command may run
+const toggleStateTimeout = 10 * time.Second
+
+// ToggleWidget is a button switching between an on and an off state.
+type ToggleWidget struct {
+	*BaseWidget
+
+	name      string
+	on        bool
+	dirty     bool
+	buttonOn  *ButtonWidget
+	buttonOff *ButtonWidget
+	actionOn  *ActionConfig
+	actionOff *ActionConfig
+	state     string
+
+	// the state command runs in the background, its result gets applied by
+	// the next update
+	mutex    sync.Mutex
+	querying bool
+	queried  bool
+	result   bool
+	// counts the toggles, so results from before a toggle get discarded
+	toggles int
+}
+
+// NewToggleWidget returns a new ToggleWidget.
+func NewToggleWidget(bw *BaseWidget, opts WidgetConfig) (*ToggleWidget, error) {
+	buttonOff, err := NewButtonWidget(bw, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the on state falls back to the appearance of the off state
+	onOpts := WidgetConfig{
+		ID:       opts.ID,
+		Interval: opts.Interval,
+		Config:   make(map[string]interface{}),
+	}
+	for k, v := range opts.Config {
+		onOpts.Config[k] = v
+	}
+	for on, off := range map[string]string{
+		"iconOn":  "icon",
+		"labelOn": "label",
+		"colorOn": "color",
+	} {
+		if v, ok := opts.Config[on]; ok {
+			onOpts.Config[off] = v
+		}
+	}
+	buttonOn, err := NewButtonWidget(bw, onOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var name, state string
+	_ = ConfigValue(opts.Config["name"], &name)
+	_ = ConfigValue(opts.Config["state"], &state)
+
+	if name == "" {
+		name = bw.file + "#" + strconv.Itoa(int(bw.key))
+	}
+	if state != "" {
+		bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, time.Second)
+	}
+
+	w := &ToggleWidget{
+		BaseWidget: bw,
+		name:       name,
+		buttonOn:   buttonOn,
+		buttonOff:  buttonOff,
+		state:      state,
+	}
+	w.on, _ = loadToggleState(name)
+
+	for cfg, a := range map[string]**ActionConfig{
+		"actionOn":  &w.actionOn,
+		"actionOff": &w.actionOff,
+	} {
+		if opts.Config[cfg] == nil {
+			continue
+		}
+
+		var ac ActionConfig
+		if err := ConfigValue(opts.Config[cfg], &ac); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", cfg, err)
+		}
+		*a = &ac
+	}
+
+	return w, nil
+}
+
+// On returns true when the toggle is switched on.
+func (w *ToggleWidget) On() bool {
+	return w.on
+}
+
+// Action returns the action switching to the other state. Without one, the
+// key's regular action gets used.
+func (w *ToggleWidget) Action() *ActionConfig {
+	a := w.actionOn
+	if w.on {
+		a = w.actionOff
+	}
+	if a == nil {
+		return w.action
+	}
+
+	return a
+}
+
+// Toggle switches the widget to its other state.
+func (w *ToggleWidget) Toggle() {
+	w.setState(!w.on)
+	w.dirty = true
+
+	w.mutex.Lock()
+	w.toggles++
+	w.queried = false
+	w.mutex.Unlock()
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *ToggleWidget) RequiresUpdate() bool {
+	w.mutex.Lock()
+	queried := w.queried
+	w.mutex.Unlock()
+
+	return w.dirty || queried || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *ToggleWidget) Update() error {
+	w.mutex.Lock()
+	queried, result := w.queried, w.result
+	w.queried = false
+	if !queried && w.state != "" && !w.querying {
+		// the result gets applied by a later update
+		w.querying = true
+		go w.query(w.toggles)
+	}
+	w.mutex.Unlock()
+
+	if queried {
+		w.setState(result)
+	}
+	w.dirty = false
+
+	if w.on {
+		return w.buttonOn.Update()
+	}
+	return w.buttonOff.Update()
+}
+
+// runs the state command. Commands may be slow, so this runs in the
+// background. toggles is the number of toggles when the command started.
+func (w *ToggleWidget) query(toggles int) {
+	ctx, cancel := context.WithTimeout(context.Background(), toggleStateTimeout)
+	defer cancel()
+	err := exec.CommandContext(ctx, "sh", "-c", w.state).Run()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.querying = false
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		fmt.Fprintf(os.Stderr, "Can't query toggle state: %s timed out\n", w.state)
+	case err != nil && !errors.As(err, &exitErr):
+		fmt.Fprintf(os.Stderr, "Can't query toggle state: %s\n", err)
+	case toggles == w.toggles:
+		// the result is stale if the widget got toggled in the meantime
+		w.queried = true
+		w.result = err == nil
+	}
+}
+
+// changes and persists the widget's state.
+func (w *ToggleWidget) setState(on bool) {
+	if on == w.on {
+		return
+	}
+
+	w.on = on
+	if err := saveToggleState(w.name, on); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't save toggle state: %s\n", err)
+	}
+}
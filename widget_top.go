@@ -1,129 +1,520 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
+	"math"
+	"os"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
 )
 
+// topModes lists the modes understood by TopWidget.
+var topModes = []string{"cpu", "core", "memory", "swap", "disk", "diskio", "network", "load", "temperature"}
+
+// the directions understood by the diskio and network modes.
+var topDirections = map[string][]string{
+	"diskio":  {"read", "write"},
+	"network": {"rx", "tx"},
+}
+
 // TopWidget is a widget displaying the current CPU/MEM usage as a bar.
 type TopWidget struct {
 	*BaseWidget
 
 	mode      string
+	label     string
+	max       float64
+	mount     string
+	device    string
+	iface     string
+	direction string
+	core      int64
+	sensor    string
 	color     color.Color
 	fillColor color.Color
+	graph     *graph
+
+	last topSample
+	// the last error, so it only gets reported once
+	lastErr string
 
-	lastValue float64
+	// previous counters to calculate usage and rates with
+	lastTimes    []cpu.TimesStat
+	lastCounters [2]uint64
+	lastSampled  time.Time
+	// the highest rate seen so far, used to scale rates without a max
+	peak float64
+}
+
+// topSample is a value sampled by TopWidget.
+type topSample struct {
+	value   float64 // the sampled value, e.g. a rate in bytes per second
+	percent float64 // how much of the bar gets filled
+	text    string  // the value, shown inside of the bar
+	unit    string
+	label   string
 }
 
 // NewTopWidget returns a new TopWidget.
-func NewTopWidget(bw *BaseWidget, opts WidgetConfig) *TopWidget {
+func NewTopWidget(bw *BaseWidget, opts WidgetConfig) (*TopWidget, error) {
 	bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, time.Second/2)
 
-	var mode string
+	var mode, label, mount, device, iface, direction, sensor string
 	_ = ConfigValue(opts.Config["mode"], &mode)
+	_ = ConfigValue(opts.Config["label"], &label)
+	_ = ConfigValue(opts.Config["mount"], &mount)
+	_ = ConfigValue(opts.Config["device"], &device)
+	_ = ConfigValue(opts.Config["interface"], &iface)
+	_ = ConfigValue(opts.Config["direction"], &direction)
+	_ = ConfigValue(opts.Config["sensor"], &sensor)
+	var max float64
+	_ = ConfigValue(opts.Config["max"], &max)
+	var core int64
+	_ = ConfigValue(opts.Config["core"], &core)
 	var color, fillColor color.Color
 	_ = ConfigValue(opts.Config["color"], &color)
 	_ = ConfigValue(opts.Config["fillColor"], &fillColor)
 
+	if mode == "" {
+		mode = "cpu"
+	}
+	if err := checkTopMode(mode, direction); err != nil {
+		return nil, err
+	}
+	if mount == "" {
+		mount = "/"
+	}
+	if color == nil {
+		color = DefaultColor
+	}
+	if fillColor == nil {
+		fillColor = DefaultFillColor
+	}
+
+	// percentages get graphed from 0 to 100, rates without a max get scaled
+	// to the samples
+	graphMax := 100.0
+	switch {
+	case max > 0:
+		graphMax = max
+	case mode == "load":
+		graphMax = float64(runtime.NumCPU())
+	case mode == "diskio" || mode == "network":
+		graphMax = math.NaN()
+	}
+	graph, err := newGraph(opts.Config, 0, graphMax, fillColor)
+	if err != nil {
+		return nil, err
+	}
+
 	return &TopWidget{
 		BaseWidget: bw,
 		mode:       mode,
+		label:      label,
+		max:        max,
+		mount:      mount,
+		device:     device,
+		iface:      iface,
+		direction:  direction,
+		core:       core,
+		sensor:     sensor,
 		color:      color,
 		fillColor:  fillColor,
+		graph:      graph,
+	}, nil
+}
+
+// checkTopMode returns an error if mode or direction aren't supported.
+func checkTopMode(mode, direction string) error {
+	known := false
+	for _, m := range topModes {
+		known = known || m == mode
+	}
+	if !known {
+		return fmt.Errorf("unknown mode %s, expected one of %s", mode, strings.Join(topModes, ", "))
+	}
+
+	if direction == "" {
+		return nil
+	}
+	dirs, ok := topDirections[mode]
+	if !ok {
+		return fmt.Errorf("mode %s doesn't support a direction", mode)
+	}
+	for _, d := range dirs {
+		if d == direction {
+			return nil
+		}
 	}
+
+	return fmt.Errorf("unknown direction %s, expected %s", direction, strings.Join(dirs, " or "))
 }
 
 // Update renders the widget.
 func (w *TopWidget) Update() error {
-	var value float64
-	var label string
+	s, err := w.sample()
+	if err != nil {
+		if err.Error() != w.lastErr {
+			fmt.Fprintf(os.Stderr, "Can't retrieve %s: %s\n", w.mode, err)
+			w.lastErr = err.Error()
+		}
+		s = topSample{text: "?", label: w.label}
+	} else {
+		w.lastErr = ""
+		if w.graph != nil {
+			w.graph.add(s.value)
+		}
+	}
 
+	// graphs move on with every sample
+	if w.last == s && w.graph == nil {
+		return nil
+	}
+	w.last = s
+
+	return w.renderSample(s)
+}
+
+// samples the widget's current value.
+func (w *TopWidget) sample() (topSample, error) {
 	switch w.mode {
-	case "cpu":
-		cpuUsage, err := cpu.Percent(0, false)
+	case "cpu", "core":
+		times, err := cpu.Times(w.mode == "core")
 		if err != nil {
-			return fmt.Errorf("can't retrieve CPU usage: %s", err)
+			return topSample{}, err
 		}
+		last := w.lastTimes
+		w.lastTimes = times
 
-		value = cpuUsage[0]
-		label = "CPU"
+		i, label := 0, "CPU"
+		if w.mode == "core" {
+			i, label = int(w.core), "CPU"+strconv.FormatInt(w.core, 10)
+		}
+		if i < 0 || i >= len(times) {
+			return topSample{}, fmt.Errorf("no such core %d", i)
+		}
+		var prev cpu.TimesStat
+		if i < len(last) {
+			prev = last[i]
+		}
+		return w.percentSample(cpuUsage(prev, times[i]), label), nil
 
 	case "memory":
 		memory, err := mem.VirtualMemory()
 		if err != nil {
-			return fmt.Errorf("can't retrieve memory usage: %s", err)
+			return topSample{}, err
+		}
+		return w.percentSample(memory.UsedPercent, "MEM"), nil
+
+	case "swap":
+		swap, err := mem.SwapMemory()
+		if err != nil {
+			return topSample{}, err
+		}
+		return w.percentSample(swap.UsedPercent, "SWAP"), nil
+
+	case "disk":
+		usage, err := disk.Usage(w.mount)
+		if err != nil {
+			return topSample{}, err
+		}
+		return w.percentSample(usage.UsedPercent, w.mount), nil
+
+	case "diskio":
+		counters, err := diskCounters(w.device)
+		if err != nil {
+			return topSample{}, err
+		}
+		return w.rateSample(counters, map[string]string{"": "DISK", "read": "READ", "write": "WRITE"}), nil
+
+	case "network":
+		counters, err := netCounters(w.iface)
+		if err != nil {
+			return topSample{}, err
 		}
-		value = memory.UsedPercent
-		label = "MEM"
+		return w.rateSample(counters, map[string]string{"": "NET", "rx": "RX", "tx": "TX"}), nil
 
-	default:
-		return fmt.Errorf("unknown widget mode: %s", w.mode)
+	case "load":
+		avg, err := load.Avg()
+		if err != nil {
+			return topSample{}, err
+		}
+		max := w.max
+		if max <= 0 {
+			max = float64(runtime.NumCPU())
+		}
+		return topSample{
+			value:   avg.Load1,
+			percent: avg.Load1 / max * 100,
+			text:    strconv.FormatFloat(avg.Load1, 'f', 2, 64),
+			label:   w.labelOr("LOAD"),
+		}, nil
+
+	case "temperature":
+		temp, err := temperature(w.sensor)
+		if err != nil {
+			return topSample{}, err
+		}
+		max := w.max
+		if max <= 0 {
+			max = 100
+		}
+		return topSample{
+			value:   temp,
+			percent: temp / max * 100,
+			text:    strconv.Itoa(int(temp)),
+			unit:    "°C",
+			label:   w.label,
+		}, nil
 	}
 
-	if w.lastValue == value {
-		return nil
+	return topSample{}, fmt.Errorf("unknown widget mode: %s", w.mode)
+}
+
+// returns the configured label, or label if there is none.
+func (w *TopWidget) labelOr(label string) string {
+	if w.label != "" {
+		return w.label
 	}
-	w.lastValue = value
+	return label
+}
 
-	if w.color == nil {
-		w.color = DefaultColor
+// returns a sample for a percentage.
+func (w *TopWidget) percentSample(value float64, label string) topSample {
+	return topSample{
+		value:   value,
+		percent: value,
+		text:    strconv.FormatInt(int64(value), 10),
+		unit:    "%",
+		label:   w.labelOr(label),
 	}
-	if w.fillColor == nil {
-		w.fillColor = color.RGBA{166, 155, 182, 255}
+}
+
+// returns a sample for the rate at which the counters for both directions
+// increased since the last sample.
+func (w *TopWidget) rateSample(counters [2]uint64, labels map[string]string) topSample {
+	now := time.Now()
+	last, lastSampled := w.lastCounters, w.lastSampled
+	w.lastCounters, w.lastSampled = counters, now
+
+	var rate float64
+	if !lastSampled.IsZero() {
+		elapsed := now.Sub(lastSampled).Seconds()
+		for i, d := range topDirections[w.mode] {
+			if (w.direction == "" || w.direction == d) && counters[i] >= last[i] {
+				rate += float64(counters[i]-last[i]) / elapsed
+			}
+		}
 	}
 
-	size := int(w.dev.Pixels)
-	margin := size / 18
+	// without a max, scale by the highest rate seen so far
+	max := w.max
+	if max <= 0 {
+		w.peak = math.Max(w.peak, rate)
+		max = w.peak
+	}
+	var percent float64
+	if max > 0 {
+		percent = rate / max * 100
+	}
+
+	text, unit := formatRate(rate)
+	return topSample{
+		value:   rate,
+		percent: percent,
+		text:    text,
+		unit:    unit,
+		label:   w.labelOr(labels[w.direction]),
+	}
+}
+
+// renders value as a bar with a percentage and label.
+func (w *TopWidget) renderValue(value float64, label string) error {
+	return w.renderSample(topSample{
+		value:   value,
+		percent: value,
+		text:    strconv.FormatInt(int64(value), 10),
+		unit:    "%",
+		label:   label,
+	})
+}
+
+// renders a sample as a bar, or as a graph of the recent samples, with its
+// value and label.
+func (w *TopWidget) renderSample(s topSample) error {
+	size := int(w.dev.Pixels())
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	label := strings.TrimSpace(s.unit + " " + s.label)
+
+	if w.graph != nil {
+		drawGraph(img, w.dev.DPI(), w.graph, s.text, label, w.color)
+	} else {
+		drawBar(img, w.dev.DPI(), s.percent, s.text, label, w.color, w.fillColor)
+	}
+
+	return w.render(w.dev, img)
+}
+
+// cpuUsage returns the percentage of time a CPU was busy between two samples.
+func cpuUsage(prev, cur cpu.TimesStat) float64 {
+	busy := func(t cpu.TimesStat) float64 {
+		return t.Total() - t.Idle - t.Iowait
+	}
+
+	total := cur.Total() - prev.Total()
+	if total <= 0 {
+		return 0
+	}
+
+	return math.Max(0, math.Min(100, (busy(cur)-busy(prev))/total*100))
+}
+
+// returns the bytes read from and written to a disk, or to all disks if
+// device is empty.
+func diskCounters(device string) ([2]uint64, error) {
+	var names []string
+	if device != "" {
+		names = append(names, device)
+	}
+
+	stats, err := disk.IOCounters(names...)
+	if err != nil {
+		return [2]uint64{}, err
+	}
+	if device != "" && len(stats) == 0 {
+		return [2]uint64{}, fmt.Errorf("no such disk %s", device)
+	}
+
+	var counters [2]uint64
+	for name, s := range stats {
+		// partitions would count twice
+		if device == "" && !isWholeDisk(name) {
+			continue
+		}
+		counters[0] += s.ReadBytes
+		counters[1] += s.WriteBytes
+	}
+
+	return counters, nil
+}
+
+// returns true if name is a disk rather than a partition.
+func isWholeDisk(name string) bool {
+	if runtime.GOOS != "linux" {
+		return true
+	}
+
+	_, err := os.Stat("/sys/block/" + name)
+	return err == nil
+}
+
+// returns the bytes received and sent by a network interface, or by all
+// interfaces except the loopback one if iface is empty.
+func netCounters(iface string) ([2]uint64, error) {
+	stats, err := net.IOCounters(true)
+	if err != nil {
+		return [2]uint64{}, err
+	}
+
+	var counters [2]uint64
+	found := false
+	for _, s := range stats {
+		if (iface == "" && s.Name != "lo") || s.Name == iface {
+			counters[0] += s.BytesRecv
+			counters[1] += s.BytesSent
+			found = true
+		}
+	}
+	if iface != "" && !found {
+		return [2]uint64{}, fmt.Errorf("no such network interface %s", iface)
+	}
+
+	return counters, nil
+}
 
-	draw.Draw(img,
-		image.Rect(12, 6, size-12, size-18),
-		&image.Uniform{w.color},
-		image.Point{}, draw.Src)
-	draw.Draw(img,
-		image.Rect(13, 7, size-14, size-20),
-		&image.Uniform{color.RGBA{0, 0, 0, 255}},
-		image.Point{}, draw.Src)
-	draw.Draw(img,
-		image.Rect(14, 7+int(float64(size-26)*(1-value/100)), size-15, size-21),
-		&image.Uniform{w.fillColor},
-		image.Point{}, draw.Src)
-
-	// draw percentage
-	bounds := img.Bounds()
-	bounds.Min.Y = 6
-	bounds.Max.Y -= 18
+// returns the highest temperature reported by the sensors whose key contains
+// sensor, in °C.
+func temperature(sensor string) (float64, error) {
+	temps, err := host.SensorsTemperatures()
+	// some sensors failing is fine, as long as others reported a temperature
+	if err != nil && len(temps) == 0 {
+		return 0, err
+	}
 
+	found := false
+	var max float64
+	for _, t := range temps {
+		if strings.Contains(t.SensorKey, sensor) {
+			if !found || t.Temperature > max {
+				max = t.Temperature
+			}
+			found = true
+		}
+	}
+	if !found {
+		if sensor == "" {
+			return 0, errors.New("no temperature sensors found")
+		}
+		return 0, fmt.Errorf("no such temperature sensor %s", sensor)
+	}
+
+	return max, nil
+}
+
+// formatRate formats a rate in bytes per second, returning the value and its
+// unit, e.g. "1.5" and "MB/s".
+func formatRate(rate float64) (string, string) {
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+
+	i := 0
+	for ; rate >= 1000 && i < len(units)-1; i++ {
+		rate /= 1000
+	}
+	if rate < 10 && i > 0 {
+		return strconv.FormatFloat(rate, 'f', 1, 64), units[i]
+	}
+
+	return strconv.Itoa(int(rate)), units[i]
+}
+
+// drawBar draws value, a percentage, as a vertical bar with text inside of
+// it and a label underneath.
+func drawBar(img *image.RGBA, dpi uint, value float64, text, label string, clr, fillColor color.Color) {
+	size := img.Bounds().Dx()
+	margin := size / 18
+	bar := image.Rect(size/6, size/12, size-size/6, size*3/4)
+
+	drawGauge(img, bar, "vbar", value, clr, fillColor)
+
+	// draw text
 	drawString(img,
-		bounds,
+		image.Rect(0, bar.Min.Y, size, bar.Max.Y),
 		ttfFont,
-		strconv.FormatInt(int64(value), 10),
-		w.dev.DPI,
+		text,
+		dpi,
 		13,
-		w.color,
+		clr,
 		image.Pt(-1, -1))
 
-	// draw description
-	bounds = img.Bounds()
-	bounds.Min.Y = size - 16
-	bounds.Max.Y -= margin
-
+	// draw label
 	drawString(img,
-		bounds,
+		image.Rect(0, bar.Max.Y+margin/2, size, size-margin),
 		ttfFont,
-		"% "+label,
-		w.dev.DPI,
+		label,
+		dpi,
 		-1,
-		w.color,
+		clr,
 		image.Pt(-1, -1))
-
-	return w.render(w.dev, img)
 }
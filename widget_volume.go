@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// VolumeWidget is a widget displaying the volume and mute state of an audio
+// device as a bar.
+type VolumeWidget struct {
+	*BaseWidget
+
+	device     audioDevice
+	label      string
+	color      color.Color
+	fillColor  color.Color
+	mutedColor color.Color
+
+	// the audio changes seen by the last update
+	changes uint64
+	// the last error, so it only gets reported once
+	lastErr string
+}
+
+// NewVolumeWidget returns a new VolumeWidget.
+func NewVolumeWidget(bw *BaseWidget, opts WidgetConfig) (*VolumeWidget, error) {
+	bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, 2*time.Second)
+
+	var kind, name, label string
+	_ = ConfigValue(opts.Config["device"], &kind)
+	_ = ConfigValue(opts.Config["name"], &name)
+	_ = ConfigValue(opts.Config["label"], &label)
+	var clr, fillColor, mutedColor color.Color
+	_ = ConfigValue(opts.Config["color"], &clr)
+	_ = ConfigValue(opts.Config["fillColor"], &fillColor)
+	_ = ConfigValue(opts.Config["mutedColor"], &mutedColor)
+
+	device, err := newAudioDevice(kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if label == "" {
+		label = map[string]string{"sink": "VOL", "source": "MIC"}[device.kind]
+	}
+	if clr == nil {
+		clr = DefaultColor
+	}
+	if fillColor == nil {
+		fillColor = DefaultFillColor
+	}
+	if mutedColor == nil {
+		mutedColor = color.RGBA{200, 60, 60, 255}
+	}
+
+	watchAudio()
+
+	return &VolumeWidget{
+		BaseWidget: bw,
+		device:     device,
+		label:      label,
+		color:      clr,
+		fillColor:  fillColor,
+		mutedColor: mutedColor,
+	}, nil
+}
+
+// Action returns the associated ActionConfig. Without one, it toggles the
+// device's mute state.
+func (w *VolumeWidget) Action() *ActionConfig {
+	if w.action != nil {
+		return w.action
+	}
+
+	return &ActionConfig{
+		Volume: &VolumeConfig{
+			Device: w.device.kind,
+			Name:   w.device.name,
+			Mute:   "toggle",
+		},
+	}
+}
+
+// RequiresUpdate returns true when the widget wants to be repainted.
+func (w *VolumeWidget) RequiresUpdate() bool {
+	return atomic.LoadUint64(&audioChanges) != w.changes || w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *VolumeWidget) Update() error {
+	w.changes = atomic.LoadUint64(&audioChanges)
+
+	size := int(w.dev.Pixels())
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	volume, muted, err := w.device.state()
+	if err == nil {
+		w.lastErr = ""
+	}
+
+	switch {
+	case err != nil:
+		if msg := strings.TrimSpace(err.Error()); msg != w.lastErr {
+			fmt.Fprintf(os.Stderr, "Can't query volume of %s: %s\n", w.device.target(), msg)
+			w.lastErr = msg
+		}
+		drawBar(img, w.dev.DPI(), 0, "?", w.label, w.color, w.fillColor)
+
+	case muted:
+		drawBar(img, w.dev.DPI(), volume, strconv.Itoa(int(volume)), "MUTED", w.color, w.mutedColor)
+
+	default:
+		drawBar(img, w.dev.DPI(), volume, strconv.Itoa(int(volume)), "% "+w.label, w.color, w.fillColor)
+	}
+
+	return w.render(w.dev, img)
+}
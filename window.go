@@ -1,10 +1,60 @@
 package main
 
 import (
-	"github.com/muesli/streamdeck"
+	"image"
+	"os"
+	"time"
 )
 
-func handleActiveWindowChanged(dev *streamdeck.Device, event ActiveWindowChangedEvent) {
+// WindowTracker provides access to the windows of a desktop session.
+type WindowTracker interface {
+	// TrackWindows emits ActiveWindowChangedEvents and WindowClosedEvents to
+	// ch.
+	TrackWindows(ch chan interface{}, timeout time.Duration)
+	ActiveWindow() Window
+	Windows() ([]Window, error)
+	RequestActivation(w Window) error
+	CloseWindow(w Window) error
+	Close()
+}
+
+// connectWindowTracker connects to the window manager of the current session:
+// sway's IPC socket, an X server, or i3's IPC socket.
+func connectWindowTracker() (WindowTracker, error) {
+	if path := os.Getenv("SWAYSOCK"); path != "" {
+		return ConnectSway(path)
+	}
+
+	x, err := Connect(os.Getenv("DISPLAY"))
+	if err == nil {
+		return x, nil
+	}
+	if path := os.Getenv("I3SOCK"); path != "" {
+		return ConnectSway(path)
+	}
+
+	return nil, err
+}
+
+// ActiveWindowChangedEvent gets emitted when the active window changes.
+type ActiveWindowChangedEvent struct {
+	Window Window
+}
+
+// WindowClosedEvent gets emitted when a window gets closed.
+type WindowClosedEvent struct {
+	Window Window
+}
+
+// Window describes a window of the desktop session.
+type Window struct {
+	ID    uint64
+	Class string
+	Name  string
+	Icon  image.Image
+}
+
+func handleActiveWindowChanged(dev Device, event ActiveWindowChangedEvent) {
 	verbosef("Active window changed to %s (%d, %s)",
 		event.Window.Class, event.Window.ID, event.Window.Name)
 
@@ -20,7 +70,7 @@ func handleActiveWindowChanged(dev *streamdeck.Device, event ActiveWindowChanged
 	}
 	recentWindows = recentWindows[:i]
 
-	keys := int(dev.Keys)
+	keys := int(dev.Keys())
 	recentWindows = append([]Window{event.Window}, recentWindows...)
 	if len(recentWindows) > keys {
 		recentWindows = recentWindows[0:keys]